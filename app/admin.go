@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/mail"
 	common "prova/common"
+	"strconv"
+	"time"
 )
 
 type Admin struct {
@@ -11,8 +13,15 @@ type Admin struct {
 	Name     string `json:"name"`
 	Surname  string `json:"surname"`
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"password,omitempty"`
 	Active   bool   `json:"admin"`
+
+	// Version è incrementata ad ogni modifica, usata per il controllo di concorrenza ottimistico.
+	Version int64 `json:"version"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
 }
 
 func (a Admin) Validate() error {
@@ -36,6 +45,27 @@ func (a Admin) Validate() error {
 	return nil
 }
 
+// Cursor restituisce il cursore dell'amministratore per l'ordinamento passato.
+func (a *Admin) Cursor(sortBy, sortDir string) Cursor {
+	return NewCursor(sortBy, sortDir, a.ID, func(field string) string {
+		switch field {
+		case "id":
+			return strconv.FormatInt(a.ID, 10)
+		case "name":
+			return a.Name
+		case "surname":
+			return a.Surname
+		case "email":
+			return a.Email
+		case "created_at":
+			return formatCursorTime(a.CreatedAt)
+		case "updated_at":
+			return formatCursorTime(a.UpdatedAt)
+		}
+		return ""
+	})
+}
+
 type AdminService interface {
 	// CreateAdmin crea un nuovo amministratore.
 	CreateAdmin(ctx context.Context, crt AdminCreate) (*Admin, error)
@@ -43,8 +73,14 @@ type AdminService interface {
 	DeleteAdmin(ctx context.Context, id int64) error
 	// FindAdminByID cerca un amministratore per ID.
 	FindAdminByID(ctx context.Context, id int64) (*Admin, error)
+	// FindAdmins cerca gli amministratori, restituisce il numero totale di risultati al netto della paginazione.
+	FindAdmins(ctx context.Context, filter AdminFilter) ([]*Admin, int, error)
+	// AuthenticateAdmin verifica le credenziali di un amministratore attivo.
+	AuthenticateAdmin(ctx context.Context, email, password string) (*Admin, error)
 	// UpdateAdmin aggiorna un amministratore.
 	UpdateAdmin(ctx context.Context, id int64, upd AdminUpdate) (*Admin, error)
+	// RestoreAdmin ripristina un amministratore eliminato.
+	RestoreAdmin(ctx context.Context, id int64) (*Admin, error)
 }
 
 type AdminCreate struct {
@@ -54,17 +90,41 @@ type AdminCreate struct {
 	Password string `json:"password"`
 }
 
+// AdminUpdate contiene i campi da modificare, il tag db indica la colonna aggiornata.
 type AdminUpdate struct {
-	Name     common.Patch[string] `json:"name"`
-	Surname  common.Patch[string] `json:"surname"`
-	Email    common.Patch[string] `json:"email"`
-	Password common.Patch[string] `json:"password"`
+	Name     common.Patch[string] `json:"name" db:"name"`
+	Surname  common.Patch[string] `json:"surname" db:"surname"`
+	Email    common.Patch[string] `json:"email" db:"email"`
+	Password common.Patch[string] `json:"password" db:"password"`
+
+	// Version è la versione attesa, se impostata e diversa da quella corrente l'aggiornamento fallisce con ECONFLICT.
+	Version *int64 `json:"version"`
 }
 
 type AdminFilter struct {
 	ID    *int64  `json:"id"`
 	Email *string `json:"email"`
 
+	// Deleted restituisce solo gli amministratori eliminati, IncludeDeleted li include insieme agli altri.
+	Deleted        bool `json:"deleted"`
+	IncludeDeleted bool `json:"include_deleted"`
+
+	CreatedAfter  *time.Time `json:"created_after"`
+	CreatedBefore *time.Time `json:"created_before"`
+
+	// SortBy è una lista separata da virgole tra id, name, surname, email, created_at e updated_at,
+	// SortDir la lista delle rispettive direzioni asc o desc, una sola direzione vale per tutti i campi.
+	SortBy  string `json:"sort_by"`
+	SortDir string `json:"sort_dir"`
+
+	// After & Before attivano la paginazione keyset, restituendo gli amministratori che seguono o precedono
+	// il cursore nell'ordinamento richiesto, in questo caso Page è ignorato.
+	After  *string `json:"after"`
+	Before *string `json:"before"`
+
+	// SkipCount evita il calcolo del numero totale di risultati, che è restituito come 0.
+	SkipCount bool `json:"skip_count"`
+
 	Page  int `json:"page"`
 	Limit int `json:"limit"`
 }
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Azioni registrate nell'audit log.
+const (
+	AuditActionCreate  = "create"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionRestore = "restore"
+)
+
+// Entità registrate nell'audit log.
+const (
+	AuditEntityUser  = "user"
+	AuditEntityAdmin = "admin"
+	AuditEntityFile  = "file"
+)
+
+// AuditLog rappresenta una modifica ad un'entità, scritta nella stessa transazione della modifica.
+type AuditLog struct {
+	ID int64 `json:"id"`
+	// ActorID is the admin that made the change, nil if the change was not made by an admin.
+	ActorID    *int64 `json:"actor_id"`
+	Action     string `json:"action"`
+	EntityType string `json:"entity_type"`
+	EntityID   int64  `json:"entity_id"`
+	// Changes contains the changed fields as {"field": {"before": ..., "after": ...}}, passwords are redacted.
+	Changes   json.RawMessage `json:"changes"`
+	IP        string          `json:"ip"`
+	RequestID string          `json:"request_id"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type AuditLogService interface {
+	// FindAuditLogs cerca le voci dell'audit log, restituisce il numero totale di risultati al netto della paginazione.
+	FindAuditLogs(ctx context.Context, filter AuditLogFilter) ([]*AuditLog, int, error)
+}
+
+type AuditLogFilter struct {
+	ActorID    *int64  `json:"actor_id"`
+	Action     *string `json:"action"`
+	EntityType *string `json:"entity_type"`
+	EntityID   *int64  `json:"entity_id"`
+
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+}
@@ -17,6 +17,9 @@ const (
 	HttpRequestTypeKey
 	localeContextKey
 	deviceContextKey
+	adminContextKey
+	remoteIPContextKey
+	requestIDContextKey
 
 	ContextParamClaims = "claims"
 	// ContextParamRole            = "role"
@@ -40,21 +43,26 @@ func Background() context.Context {
 // 	return time.Now().UTC()
 // }
 
-// // NewContextWithLocale returns a new context with the provided locale attached.
-// func NewContextWithLocale(ctx context.Context, locale string) context.Context {
-// 	return context.WithValue(ctx, localeContextKey, locale)
-// }
+// NewContextWithLocale returns a new context with the provided locale attached.
+func NewContextWithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeContextKey, locale)
+}
 
-// // NewContextWithTx returns a new context with provided tx attached.
-// // This ca be useful to implements multi layer transactions.
-// // func NewContextWithTx(ctx context.Context, tx PendingTx) context.Context {
-// // 	return context.WithValue(ctx, txContextKey, tx)
-// // }
+// NewContextWithTx returns a new context with provided tx attached.
+// This ca be useful to implements multi layer transactions.
+func NewContextWithTx(ctx context.Context, tx PendingTx) context.Context {
+	return context.WithValue(ctx, txContextKey, tx)
+}
 
-// // NewContextWithUser returns a new context with the provided user attached.
-// func NewContextWithUser(ctx context.Context, user *User) context.Context {
-// 	return context.WithValue(ctx, userContextKey, user)
-// }
+// NewContextWithAdmin returns a new context with the provided admin attached.
+func NewContextWithAdmin(ctx context.Context, admin *Admin) context.Context {
+	return context.WithValue(ctx, adminContextKey, admin)
+}
+
+// NewContextWithUser returns a new context with the provided user attached.
+func NewContextWithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
 
 // // NewContextWithHttpRequestType returns a new context with the previded http req type attached.
 // func NewContextWithHttpRequestType(ctx context.Context, reqType string) context.Context {
@@ -79,31 +87,31 @@ func Background() context.Context {
 // 	return HttpRequestTypeFromContext(ctx) == HttpRequestTypeAPI
 // }
 
-// // IsLocalizedContext returns true if the provided context has a locale attached.
-// func IsLocalizedContext(ctx context.Context) bool {
-// 	return rawLocaleFromContext(ctx) != ""
-// }
+// IsLocalizedContext returns true if the provided context has a locale attached.
+func IsLocalizedContext(ctx context.Context) bool {
+	return rawLocaleFromContext(ctx) != ""
+}
 
-// // LocaleFromContext returns the locale stored in the provided context, if no locale is stored, the default locale is returned.
-// func LocaleFromContext(ctx context.Context) string {
-// 	locale := rawLocaleFromContext(ctx)
-// 	if locale == "" {
-// 		return DefaultLocale
-// 	}
-// 	return locale
-// }
+// LocaleFromContext returns the locale stored in the provided context, if no locale is stored, the default locale is returned.
+func LocaleFromContext(ctx context.Context) string {
+	locale := rawLocaleFromContext(ctx)
+	if locale == "" {
+		return DefaultLocale
+	}
+	return locale
+}
 
-// // RawLocaleFromContext returns the raw locale stored in the provided context.
-// func rawLocaleFromContext(ctx context.Context) string {
-// 	if ctx == nil {
-// 		return ""
-// 	}
-// 	locale, ok := ctx.Value(localeContextKey).(string)
-// 	if !ok {
-// 		return ""
-// 	}
-// 	return locale
-// }
+// RawLocaleFromContext returns the raw locale stored in the provided context.
+func rawLocaleFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	locale, ok := ctx.Value(localeContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return locale
+}
 
 // TxFromContext returns the transaction stored inside the context.
 func TxFromContext(ctx context.Context) PendingTx {
@@ -117,17 +125,57 @@ func TxFromContext(ctx context.Context) PendingTx {
 	return tx
 }
 
-// // UserFromContext returns the user stored in the provided context.
-// func UserFromContext(ctx context.Context) *User {
-// 	if ctx == nil {
-// 		return nil
-// 	}
-// 	user, ok := ctx.Value(userContextKey).(*User)
-// 	if !ok {
-// 		return nil
-// 	}
-// 	return user
-// }
+// NewContextWithRemoteIP returns a new context with the IP of the client attached.
+func NewContextWithRemoteIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, remoteIPContextKey, ip)
+}
+
+// RemoteIPFromContext returns the IP of the client stored in the provided context.
+func RemoteIPFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	ip, _ := ctx.Value(remoteIPContextKey).(string)
+	return ip
+}
+
+// NewContextWithRequestID returns a new context with the request ID attached.
+func NewContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestIDFromContext returns the request ID stored in the provided context.
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// AdminFromContext returns the admin stored in the provided context.
+func AdminFromContext(ctx context.Context) *Admin {
+	if ctx == nil {
+		return nil
+	}
+	admin, ok := ctx.Value(adminContextKey).(*Admin)
+	if !ok {
+		return nil
+	}
+	return admin
+}
+
+// UserFromContext returns the user stored in the provided context.
+func UserFromContext(ctx context.Context) *User {
+	if ctx == nil {
+		return nil
+	}
+	user, ok := ctx.Value(userContextKey).(*User)
+	if !ok {
+		return nil
+	}
+	return user
+}
 
 // // UserIDFromContext returns the user ID stored in the provided context.
 // func UserIDFromContext(ctx context.Context) int64 {
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cursorTimeLayout è il formato dei timestamp nei cursori, senza fuso orario perché
+// le colonne sono TIMESTAMP in UTC.
+const cursorTimeLayout = "2006-01-02T15:04:05.999999"
+
+// Cursor è la posizione di un elemento nell'ordinamento della paginazione keyset, i client lo ricevono
+// e lo restituiscono come stringa opaca.
+type Cursor struct {
+	// SortBy & SortDir sono quelli del filtro con cui è stato generato il cursore,
+	// un cursore non può essere usato con un ordinamento diverso.
+	SortBy  string `json:"b,omitempty"`
+	SortDir string `json:"d,omitempty"`
+
+	// Values sono i valori dei campi di SortBy seguiti dall'ID dell'elemento.
+	Values []string `json:"v"`
+}
+
+// NewCursor crea il cursore di un elemento, value restituisce il valore dell'elemento per un campo di sortBy.
+func NewCursor(sortBy, sortDir string, id int64, value func(field string) string) Cursor {
+
+	c := Cursor{SortBy: sortBy, SortDir: sortDir}
+
+	if sortBy != "" {
+		for _, field := range strings.Split(sortBy, ",") {
+			c.Values = append(c.Values, value(strings.TrimSpace(field)))
+		}
+	}
+
+	c.Values = append(c.Values, strconv.FormatInt(id, 10))
+
+	return c
+}
+
+// formatCursorTime formatta un timestamp come valore di un cursore.
+func formatCursorTime(t time.Time) string {
+	return t.UTC().Format(cursorTimeLayout)
+}
+
+// Encode restituisce il cursore codificato come stringa opaca.
+func (c Cursor) Encode() string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// DecodeCursor decodifica un cursore restituito da Cursor.Encode, verificando che sia stato generato
+// con l'ordinamento passato.
+func DecodeCursor(s, sortBy, sortDir string) (Cursor, error) {
+
+	var c Cursor
+
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, Errorf(EINVALID, "Invalid cursor")
+	} else if err := json.Unmarshal(buf, &c); err != nil || len(c.Values) == 0 {
+		return Cursor{}, Errorf(EINVALID, "Invalid cursor")
+	} else if c.SortBy != sortBy || c.SortDir != sortDir {
+		return Cursor{}, Errorf(EINVALID, "Cursor doesn't match the requested sort")
+	}
+
+	return c, nil
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+
+	valid := NewCursor("name,created_at", "asc,desc", 42, func(field string) string {
+		return map[string]string{"name": "Mario", "created_at": "2024-01-02T03:04:05"}[field]
+	})
+
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		sortBy  string
+		sortDir string
+		want    []string
+		code    string
+	}{
+		{"valid", valid.Encode(), "name,created_at", "asc,desc", []string{"Mario", "2024-01-02T03:04:05", "42"}, ""},
+		{"id only", NewCursor("", "", 7, nil).Encode(), "", "", []string{"7"}, ""},
+		{"other sort field", valid.Encode(), "name", "asc,desc", nil, EINVALID},
+		{"other sort direction", valid.Encode(), "name,created_at", "desc", nil, EINVALID},
+		{"not base64", "***", "", "", nil, EINVALID},
+		{"not json", encode("nope"), "", "", nil, EINVALID},
+		{"no values", encode(`{"v":[]}`), "", "", nil, EINVALID},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"v":["1"]}`)), "", "", nil, EINVALID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			c, err := DecodeCursor(tt.cursor, tt.sortBy, tt.sortDir)
+			if code := ErrorCode(err); code != tt.code {
+				t.Fatalf("error = %v, want code %q", err, tt.code)
+			}
+
+			if tt.code == "" && !reflect.DeepEqual(c.Values, tt.want) {
+				t.Errorf("values = %q, want %q", c.Values, tt.want)
+			}
+		})
+	}
+}
@@ -57,6 +57,10 @@ type Error struct {
 
 	// OriginFn fn where error was raised.
 	OriginFn string
+
+	// format & args are the message before formatting, the format is the key used to translate it.
+	format string
+	args   []any
 }
 
 // Error implements the error interface.
@@ -99,6 +103,21 @@ func ErrorMessage(err error) string {
 	return err.Error()
 }
 
+// ErrorMessageLocale unwraps an application error and returns its message translated in locale.
+// Non-application errors return their error string.
+func ErrorMessageLocale(err error, locale string) string {
+	var e *Error
+	if err == nil {
+		return ""
+	} else if errors.As(err, &e) {
+		if e.format == "" {
+			return e.Message
+		}
+		return Translate(locale, e.format, e.args...)
+	}
+	return err.Error()
+}
+
 // Errorf is a helper function to return an Error with a given code and formatted message.
 func Errorf(code string, format string, args ...interface{}) *Error {
 
@@ -115,5 +134,7 @@ func Errorf(code string, format string, args ...interface{}) *Error {
 		Message:    message,
 		OriginFile: fmt.Sprint(caller),
 		OriginFn:   fmt.Sprintf("%+n", caller),
+		format:     format,
+		args:       args,
 	}
 }
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Event types published by the application services.
+const (
+	EventTypeAll = "*" // subscribes to every event type
+
+	EventTypeUserCreated  = "user.created"
+	EventTypeUserUpdated  = "user.updated"
+	EventTypeUserDeleted  = "user.deleted"
+	EventTypeUserRestored = "user.restored"
+
+	EventTypeAdminCreated  = "admin.created"
+	EventTypeAdminUpdated  = "admin.updated"
+	EventTypeAdminDeleted  = "admin.deleted"
+	EventTypeAdminRestored = "admin.restored"
+
+	EventTypeFileCreated = "file.created"
+	EventTypeFileDeleted = "file.deleted"
+)
+
+// EventTypes contains all the event types published by the application.
+var EventTypes = []string{
+	EventTypeUserCreated,
+	EventTypeUserUpdated,
+	EventTypeUserDeleted,
+	EventTypeUserRestored,
+	EventTypeAdminCreated,
+	EventTypeAdminUpdated,
+	EventTypeAdminDeleted,
+	EventTypeAdminRestored,
+	EventTypeFileCreated,
+	EventTypeFileDeleted,
+}
+
+// IsValidEventType returns true if the given type is a known event type or EventTypeAll.
+func IsValidEventType(eventType string) bool {
+	if eventType == EventTypeAll {
+		return true
+	}
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event rappresenta un evento di dominio pubblicato tramite outbox.
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	EntityID  int64           `json:"entity_id"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// EventHandler defines a subscriber func, a returned error causes the event to be retried.
+type EventHandler func(ctx context.Context, e Event) error
+
+type EventService interface {
+	// Subscribe registra un handler per il tipo di evento passato, EventTypeAll riceve tutti gli eventi.
+	Subscribe(eventType string, h EventHandler)
+}
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"context"
+	"io"
+	"mime"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Proprietari dei file.
+const (
+	FileOwnerUser  = "user"
+	FileOwnerAdmin = "admin"
+)
+
+// Tipi di file caricabili.
+const (
+	FileKindAvatar   = "avatar"
+	FileKindDocument = "document"
+)
+
+// FileRule definisce dimensione massima e MIME type accettati per un tipo di file.
+type FileRule struct {
+	MaxSize   int64
+	MimeTypes []string
+}
+
+// FileRules contiene le regole di ciascun tipo di file.
+var FileRules = map[string]FileRule{
+	FileKindAvatar: {
+		MaxSize:   5 << 20,
+		MimeTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+	},
+	FileKindDocument: {
+		MaxSize:   20 << 20,
+		MimeTypes: []string{"application/pdf", "image/jpeg", "image/png", "text/plain"},
+	},
+}
+
+// MaxFileSize è la dimensione massima tra tutti i tipi di file.
+func MaxFileSize() int64 {
+	var n int64
+	for _, rule := range FileRules {
+		n = max(n, rule.MaxSize)
+	}
+	return n
+}
+
+// File contiene i metadati di un file salvato nel FileStorage.
+type File struct {
+	ID        int64  `json:"id"`
+	OwnerType string `json:"owner_type"`
+	OwnerID   int64  `json:"owner_id"`
+	Kind      string `json:"kind"`
+	Filename  string `json:"filename"`
+	MimeType  string `json:"mime_type"`
+	Size      int64  `json:"size"`
+	// Checksum è lo SHA-256 esadecimale del contenuto.
+	Checksum string `json:"checksum"`
+	// StorageKey è la chiave del contenuto nel FileStorage.
+	StorageKey string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// Variants sono le versioni ridimensionate di un'immagine per nome, vuote finché non sono generate.
+	Variants map[string]*FileVariant `json:"variants,omitempty"`
+}
+
+// FileVariant è una versione ridimensionata di un'immagine, URL è impostato dal livello HTTP.
+type FileVariant struct {
+	MimeType string `json:"mime_type"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	URL      string `json:"url,omitempty"`
+}
+
+// VariantStorageKey restituisce la chiave nel FileStorage della variante name del file.
+func (f *File) VariantStorageKey(name string) string {
+	return f.StorageKey + "-" + name
+}
+
+// ImageVariant definisce una variante da generare, l'immagine è ridotta per stare in un quadrato di lato MaxSize.
+type ImageVariant struct {
+	Name    string
+	MaxSize int
+}
+
+// Varianti degli avatar.
+const (
+	AvatarVariantThumbnail = "thumbnail"
+	AvatarVariantMedium    = "medium"
+	AvatarVariantLarge     = "large"
+)
+
+// AvatarVariants sono le varianti generate per ogni avatar.
+var AvatarVariants = []ImageVariant{
+	{Name: AvatarVariantThumbnail, MaxSize: 64},
+	{Name: AvatarVariantMedium, MaxSize: 256},
+	{Name: AvatarVariantLarge, MaxSize: 1024},
+}
+
+// FileStorage salva il contenuto dei file, le chiavi sono percorsi separati da / senza . e ..
+type FileStorage interface {
+	// Put salva size byte letti da r con la chiave key, sostituendo un contenuto esistente.
+	Put(ctx context.Context, key string, r io.Reader, size int64, mimeType string) error
+	// Open apre il contenuto salvato con la chiave key, ENOTFOUND se non esiste.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete elimina il contenuto salvato con la chiave key, senza errori se non esiste.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidateStorageKey verifica che key sia una chiave valida per un FileStorage.
+func ValidateStorageKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return Errorf(EINVALID, "Invalid storage key %q", key)
+	}
+	return nil
+}
+
+type FileService interface {
+	// CreateFile salva il contenuto del file caricato e ne registra i metadati.
+	CreateFile(ctx context.Context, upl FileUpload) (*File, error)
+	// FindFileByID cerca un file tramite ID tra quelli accessibili dall'utente autenticato.
+	FindFileByID(ctx context.Context, id int64) (*File, error)
+	// FindFiles cerca i file, restituisce il numero totale di risultati al netto della paginazione.
+	FindFiles(ctx context.Context, filter FileFilter) ([]*File, int, error)
+	// OpenFile apre il contenuto di un file o della sua variante se variant non è vuoto, il chiamante deve chiuderlo.
+	OpenFile(ctx context.Context, id int64, variant string) (*File, io.ReadSeekCloser, error)
+	// DeleteFile elimina un file e il suo contenuto.
+	DeleteFile(ctx context.Context, id int64) error
+}
+
+// FileUpload è un file caricato, Body contiene Size byte.
+type FileUpload struct {
+	OwnerType string
+	OwnerID   int64
+	Kind      string
+	Filename  string
+	MimeType  string
+	Size      int64
+	Body      io.Reader
+}
+
+// Validate verifica il file caricato con le regole del suo tipo.
+func (u FileUpload) Validate() error {
+
+	rule, ok := FileRules[u.Kind]
+	if !ok {
+		return Errorf(EINVALID, "Invalid file kind")
+	}
+
+	if u.OwnerType != FileOwnerUser && u.OwnerType != FileOwnerAdmin {
+		return Errorf(EINVALID, "Invalid file owner")
+	}
+
+	if u.OwnerID == 0 {
+		return Errorf(EINVALID, "File owner is required")
+	}
+
+	if u.Filename == "" {
+		return Errorf(EINVALID, "Filename is required")
+	}
+
+	if u.Size <= 0 {
+		return Errorf(EINVALID, "File is empty")
+	} else if u.Size > rule.MaxSize {
+		return Errorf(EINVALID, "File exceeds the maximum size of %d MB", rule.MaxSize>>20)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(u.MimeType)
+	if err != nil || !slices.Contains(rule.MimeTypes, mediaType) {
+		return Errorf(EINVALID, "File type %s is not allowed", u.MimeType)
+	}
+
+	return nil
+}
+
+type FileFilter struct {
+	ID        *int64  `json:"id"`
+	OwnerType *string `json:"owner_type"`
+	OwnerID   *int64  `json:"owner_id"`
+	OwnerIDs  []int64 `json:"owner_ids"`
+	Kind      *string `json:"kind"`
+
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+}
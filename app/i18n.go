@@ -0,0 +1,83 @@
+package app
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// Messages contiene i cataloghi dei messaggi per lingua, caricati dai file locales/<lingua>.json.
+var Messages = map[string]map[string]string{}
+
+func init() {
+
+	files, err := localesFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, f := range files {
+
+		b, err := localesFS.ReadFile(path.Join("locales", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		catalog := map[string]string{}
+		if err := json.Unmarshal(b, &catalog); err != nil {
+			panic(fmt.Sprintf("invalid catalog %s: %v", f.Name(), err))
+		}
+
+		Messages[strings.TrimSuffix(f.Name(), ".json")] = catalog
+	}
+}
+
+// Locales restituisce le lingue con un catalogo, in ordine alfabetico.
+func Locales() []string {
+	locales := make([]string, 0, len(Messages))
+	for locale := range Messages {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+// IsLocale indica se esiste il catalogo della lingua locale.
+func IsLocale(locale string) bool {
+	_, ok := Messages[locale]
+	return ok
+}
+
+// HasMessage indica se key è presente nei cataloghi di tutte le lingue.
+func HasMessage(key string) bool {
+	for _, catalog := range Messages {
+		if _, ok := catalog[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Translate restituisce il messaggio key nella lingua locale formattato con args, se manca usa la lingua
+// di default e infine la chiave stessa.
+func Translate(locale, key string, args ...any) string {
+
+	msg, ok := Messages[locale][key]
+	if !ok {
+		if msg, ok = Messages[DefaultLocale][key]; !ok {
+			msg = key
+		}
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+
+	return msg
+}
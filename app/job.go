@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Stati di un job.
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusDead      = "dead" // max attempts reached, the job is dead-lettered
+)
+
+// Tipi di job eseguiti dall'applicazione.
+const (
+	// JobKindProcessImage normalizza un'immagine caricata e ne genera le varianti.
+	JobKindProcessImage = "process_image"
+)
+
+// Job rappresenta un lavoro da eseguire in background, fuori dalla richiesta.
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error"`
+	RunAt       time.Time       `json:"run_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at"`
+}
+
+// UnmarshalPayload decodes the job payload into v.
+func (j Job) UnmarshalPayload(v any) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return Errorf(EINVALID, "Invalid payload for job %q: %v", j.Kind, err)
+	}
+	return nil
+}
+
+// JobHandler esegue un job, un errore restituito causa un nuovo tentativo.
+// Job delivery is at-least-once so handlers must be idempotent.
+type JobHandler func(ctx context.Context, job *Job) error
+
+type JobService interface {
+	// Enqueue accoda un job da eseguire a partire da runAt. Se il context contiene una transazione,
+	// il job viene accodato al suo interno e diventa visibile ai worker solo dopo il commit.
+	Enqueue(ctx context.Context, kind string, payload any, runAt time.Time) (*Job, error)
+	// Handle registra l'handler per il tipo di job passato.
+	Handle(kind string, h JobHandler)
+	// FindJobs cerca i job, restituisce il numero totale di risultati al netto della paginazione.
+	FindJobs(ctx context.Context, filter JobFilter) ([]*Job, int, error)
+	// RetryJob rimette in coda un job dead-lettered azzerandone i tentativi.
+	RetryJob(ctx context.Context, id int64) (*Job, error)
+}
+
+type JobFilter struct {
+	ID     *int64  `json:"id"`
+	Kind   *string `json:"kind"`
+	Status *string `json:"status"`
+
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+}
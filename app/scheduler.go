@@ -0,0 +1,42 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+// Stati dell'esecuzione di un task schedulato.
+const (
+	TaskRunStatusSucceeded = "succeeded"
+	TaskRunStatusFailed    = "failed"
+)
+
+// TaskFunc esegue un task di manutenzione ricorrente.
+type TaskFunc func(ctx context.Context) error
+
+// TaskRun rappresenta l'esecuzione di un task schedulato.
+type TaskRun struct {
+	ID   int64  `json:"id"`
+	Task string `json:"task"`
+	// ScheduledAt is the cron tick the run belongs to.
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+type SchedulerService interface {
+	// Schedule registra un task ricorrente con un'espressione cron (es. "0 3 * * *").
+	Schedule(name, spec string, fn TaskFunc) error
+	// FindTaskRuns cerca le esecuzioni dei task, restituisce il numero totale di risultati al netto della paginazione.
+	FindTaskRuns(ctx context.Context, filter TaskRunFilter) ([]*TaskRun, int, error)
+}
+
+type TaskRunFilter struct {
+	Task   *string `json:"task"`
+	Status *string `json:"status"`
+
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+}
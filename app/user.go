@@ -3,6 +3,9 @@ package app
 import (
 	"context"
 	"net/mail"
+	"strconv"
+	"time"
+
 	common "prova/common"
 )
 
@@ -11,8 +14,18 @@ type User struct {
 	Name     string `json:"name"`
 	Surname  string `json:"surname"`
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"password,omitempty"`
 	Phone    int64  `json:"phone"`
+
+	// Version è incrementata ad ogni modifica, usata per il controllo di concorrenza ottimistico.
+	Version int64 `json:"version"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+
+	// Avatar è l'immagine del profilo con le sue varianti, nil se non è stata caricata.
+	Avatar *File `json:"avatar,omitempty"`
 }
 
 func (u User) Validate() error {
@@ -40,6 +53,29 @@ func (u User) Validate() error {
 	return nil
 }
 
+// Cursor restituisce il cursore dello user per l'ordinamento passato.
+func (u *User) Cursor(sortBy, sortDir string) Cursor {
+	return NewCursor(sortBy, sortDir, u.ID, func(field string) string {
+		switch field {
+		case "id":
+			return strconv.FormatInt(u.ID, 10)
+		case "name":
+			return u.Name
+		case "surname":
+			return u.Surname
+		case "email":
+			return u.Email
+		case "phone":
+			return strconv.FormatInt(u.Phone, 10)
+		case "created_at":
+			return formatCursorTime(u.CreatedAt)
+		case "updated_at":
+			return formatCursorTime(u.UpdatedAt)
+		}
+		return ""
+	})
+}
+
 type UserService interface {
 	// CreateUser crea un nuovo user
 	CreateUser(ctx context.Context, crt UserCreate) (*User, error)
@@ -49,8 +85,20 @@ type UserService interface {
 	FindUserByID(ctx context.Context, id int64) (*User, error)
 	// UpdateUser aggiorna un User
 	UpdateUser(ctx context.Context, id int64, upd UserUpdate) (*User, error)
+	// AuthenticateUser verifica le credenziali di uno user non eliminato.
+	AuthenticateUser(ctx context.Context, email, password string) (*User, error)
 
 	FindUsers(ctx context.Context, filter UserFilter) ([]*User, int, error)
+	// SearchUsers cerca gli user per frammenti di nome, cognome ed email, anche con errori di battitura,
+	// restituendo i risultati ordinati per rilevanza.
+	SearchUsers(ctx context.Context, q string, limit int) ([]*User, error)
+	// RestoreUser ripristina un user eliminato
+	RestoreUser(ctx context.Context, id int64) (*User, error)
+	// ImportUsers crea gli user letti da src, restituendo l'esito di ogni riga.
+	ImportUsers(ctx context.Context, src UserImportSource, opts UserImportOptions) (*UserImportReport, error)
+	// ExportUsers chiama fn per ogni user del filtro, ignorandone la paginazione. Gli user sono letti
+	// man mano, un errore restituito da fn interrompe l'export.
+	ExportUsers(ctx context.Context, filter UserFilter, fn func(*User) error) error
 }
 
 type UserCreate struct {
@@ -61,17 +109,49 @@ type UserCreate struct {
 	Phone    int64  `json:"phone"`
 }
 
+// UserUpdate contiene i campi da modificare, il tag db indica la colonna aggiornata.
 type UserUpdate struct {
-	Name     common.Patch[string] `json:"name"`
-	Surname  common.Patch[string] `json:"surname"`
-	Email    common.Patch[string] `json:"email"`
-	Password common.Patch[string] `json:"password"`
+	Name     common.Patch[string] `json:"name" db:"name"`
+	Surname  common.Patch[string] `json:"surname" db:"surname"`
+	Email    common.Patch[string] `json:"email" db:"email"`
+	Password common.Patch[string] `json:"password" db:"password"`
+	Phone    common.Patch[int64]  `json:"phone" db:"phone"`
+
+	// Version è la versione attesa, se impostata e diversa da quella corrente l'aggiornamento fallisce con ECONFLICT.
+	Version *int64 `json:"version"`
 }
 
 type UserFilter struct {
-	ID    *int64  `json:"id"`
+	ID  *int64  `json:"id"`
+	IDs []int64 `json:"ids"`
+	// Email è confrontata senza distinzione tra maiuscole e minuscole.
 	Email *string `json:"email"`
 
+	// Search cerca senza distinzione tra maiuscole e minuscole in nome, cognome ed email.
+	Search *string `json:"search"`
+	// PhonePrefix cerca gli user il cui telefono inizia con le cifre passate.
+	PhonePrefix *string `json:"phone_prefix"`
+
+	// Deleted restituisce solo gli user eliminati, IncludeDeleted li include insieme agli altri.
+	Deleted        bool `json:"deleted"`
+	IncludeDeleted bool `json:"include_deleted"`
+
+	CreatedAfter  *time.Time `json:"created_after"`
+	CreatedBefore *time.Time `json:"created_before"`
+
+	// SortBy è una lista separata da virgole tra id, name, surname, email, phone, created_at e updated_at,
+	// SortDir la lista delle rispettive direzioni asc o desc, una sola direzione vale per tutti i campi.
+	SortBy  string `json:"sort_by"`
+	SortDir string `json:"sort_dir"`
+
+	// After & Before attivano la paginazione keyset, restituendo gli user che seguono o precedono il cursore
+	// nell'ordinamento richiesto, in questo caso Page è ignorato.
+	After  *string `json:"after"`
+	Before *string `json:"before"`
+
+	// SkipCount evita il calcolo del numero totale di risultati, che è restituito come 0.
+	SkipCount bool `json:"skip_count"`
+
 	Page  int `json:"page"`
 	Limit int `json:"limit"`
-}
\ No newline at end of file
+}
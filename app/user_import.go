@@ -0,0 +1,208 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formati accettati dall'import degli user.
+const (
+	UserImportFormatCSV   = "csv"
+	UserImportFormatJSONL = "jsonl"
+)
+
+// Esiti dell'import di una riga.
+const (
+	UserImportStatusCreated        = "created"
+	UserImportStatusDuplicateEmail = "duplicate_email"
+	UserImportStatusInvalidPhone   = "invalid_phone"
+	UserImportStatusInvalid        = "invalid"
+	// UserImportStatusSkipped è l'esito delle righe valide di un import AllOrNothing annullato.
+	UserImportStatusSkipped = "skipped"
+)
+
+// UserImportRow è una riga letta dal file importato, Err è impostato se la riga non è leggibile.
+// Un telefono non numerico è letto come zero.
+type UserImportRow struct {
+	Line int
+	User UserCreate
+	Err  error
+}
+
+// UserImportSource restituisce le righe da importare, io.EOF al termine.
+type UserImportSource interface {
+	Next() (UserImportRow, error)
+}
+
+// UserImportOptions definisce la modalità di import.
+type UserImportOptions struct {
+	// AllOrNothing importa gli user in una sola transazione, annullata se almeno una riga non è importata.
+	// Dopo la prima riga non importata le successive sono solo verificate.
+	AllOrNothing bool
+	// BatchSize è il numero di righe inserite con una sola query, 500 se zero e al massimo 5000.
+	BatchSize int
+}
+
+// UserImportResult è l'esito dell'import di una riga.
+type UserImportResult struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	UserID int64  `json:"user_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// UserImportReport riassume l'esito dell'import, se Committed è false nessuno user è stato creato.
+type UserImportReport struct {
+	Results   []UserImportResult `json:"results"`
+	Created   int                `json:"created"`
+	Failed    int                `json:"failed"`
+	Committed bool               `json:"committed"`
+}
+
+// NewUserImportSource restituisce le righe lette da r nel formato passato. I file CSV devono avere
+// un'intestazione con le colonne name, surname, email, password e phone, in qualsiasi ordine.
+func NewUserImportSource(r io.Reader, format string) (UserImportSource, error) {
+	switch format {
+	case UserImportFormatCSV:
+		return newCSVUserImportSource(r)
+	case UserImportFormatJSONL:
+		return &jsonlUserImportSource{scanner: bufio.NewScanner(r)}, nil
+	}
+	return nil, Errorf(EINVALID, "Invalid import format %q", format)
+}
+
+// csvUserImportSource legge le righe di un file CSV.
+type csvUserImportSource struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVUserImportSource(r io.Reader) (*csvUserImportSource, error) {
+
+	s := &csvUserImportSource{r: csv.NewReader(r), columns: map[string]int{}}
+	s.r.FieldsPerRecord = -1
+
+	header, err := s.r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, Errorf(EINVALID, "Empty CSV file")
+	} else if err != nil {
+		return nil, Errorf(EINVALID, "Invalid CSV header: %v", err)
+	}
+
+	for i, name := range header {
+		s.columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"name", "surname", "email", "password", "phone"} {
+		if _, ok := s.columns[name]; !ok {
+			return nil, Errorf(EINVALID, "Missing CSV column %q", name)
+		}
+	}
+
+	return s, nil
+}
+
+// Next implements UserImportSource.
+func (s *csvUserImportSource) Next() (UserImportRow, error) {
+
+	record, err := s.r.Read()
+	if errors.Is(err, io.EOF) {
+		return UserImportRow{}, io.EOF
+	}
+
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		return UserImportRow{Line: perr.StartLine, Err: Errorf(EINVALID, "Invalid CSV row: %v", perr.Err)}, nil
+	} else if err != nil {
+		return UserImportRow{}, Errorf(EINVALID, "Error reading CSV: %v", err)
+	}
+
+	// quoted fields may span several lines, the row is numbered by its first one.
+	line, _ := s.r.FieldPos(0)
+	row := UserImportRow{Line: line}
+
+	field := func(name string) string {
+		if i := s.columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	row.User = UserCreate{
+		Name:     field("name"),
+		Surname:  field("surname"),
+		Email:    field("email"),
+		Password: field("password"),
+	}
+
+	row.User.Phone = parseImportPhone(field("phone"))
+
+	return row, nil
+}
+
+// jsonlUserImportSource legge le righe di un file JSON lines, un oggetto per riga.
+type jsonlUserImportSource struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// Next implements UserImportSource.
+func (s *jsonlUserImportSource) Next() (UserImportRow, error) {
+
+	for s.scanner.Scan() {
+
+		s.line++
+
+		b := bytes.TrimSpace(s.scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		row := UserImportRow{Line: s.line}
+
+		// phone is decoded apart, it may be written either as number or string.
+		var v struct {
+			Name     string          `json:"name"`
+			Surname  string          `json:"surname"`
+			Email    string          `json:"email"`
+			Password string          `json:"password"`
+			Phone    json.RawMessage `json:"phone"`
+		}
+
+		if err := json.Unmarshal(b, &v); err != nil {
+			row.Err = Errorf(EINVALID, "Invalid JSON row: %v", err)
+			return row, nil
+		}
+
+		row.User = UserCreate{Name: v.Name, Surname: v.Surname, Email: v.Email, Password: v.Password}
+		row.User.Phone = parseImportPhone(strings.Trim(string(v.Phone), `"`))
+
+		return row, nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return UserImportRow{}, Errorf(EINVALID, "Error reading JSON lines: %v", err)
+	}
+
+	return UserImportRow{}, io.EOF
+}
+
+// parseImportPhone converte il telefono letto dal file, accettando spazi e un + iniziale, zero se non è valido.
+func parseImportPhone(s string) int64 {
+
+	s = strings.TrimPrefix(strings.ReplaceAll(strings.TrimSpace(s), " ", ""), "+")
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return n
+}
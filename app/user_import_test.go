@@ -0,0 +1,126 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUserImportSource(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []UserImportRow
+		// errs lists the lines of the rows expected to be unreadable.
+		errs []int
+	}{
+		{
+			name:   "csv",
+			format: UserImportFormatCSV,
+			input:  "email,name,surname,password,phone\nmario@example.com, Mario ,Rossi,secret,+39 333 1234567\n",
+			want: []UserImportRow{
+				{Line: 2, User: UserCreate{Name: "Mario", Surname: "Rossi", Email: "mario@example.com", Password: "secret", Phone: 393331234567}},
+			},
+		},
+		{
+			name:   "csv header case and multiline field",
+			format: UserImportFormatCSV,
+			input:  "Name,SURNAME,Email,Password,Phone\n\"Anna\nMaria\",Bianchi,anna@example.com,secret,123\nLuca,Verdi,luca@example.com,secret,abc\n",
+			want: []UserImportRow{
+				{Line: 2, User: UserCreate{Name: "Anna\nMaria", Surname: "Bianchi", Email: "anna@example.com", Password: "secret", Phone: 123}},
+				{Line: 4, User: UserCreate{Name: "Luca", Surname: "Verdi", Email: "luca@example.com", Password: "secret"}},
+			},
+		},
+		{
+			name:   "csv short row",
+			format: UserImportFormatCSV,
+			input:  "name,surname,email,password,phone\nMario,Rossi\n",
+			want: []UserImportRow{
+				{Line: 2, User: UserCreate{Name: "Mario", Surname: "Rossi"}},
+			},
+		},
+		{
+			name:   "csv invalid row",
+			format: UserImportFormatCSV,
+			input:  "name,surname,email,password,phone\nMa\"rio,Rossi,mario@example.com,secret,1\n",
+			errs:   []int{2},
+		},
+		{
+			name:   "jsonl",
+			format: UserImportFormatJSONL,
+			input:  "{\"name\":\"Mario\",\"email\":\"mario@example.com\",\"phone\":3331234567}\n\n{\"name\":\"Anna\",\"phone\":\"+39 123\"}\n",
+			want: []UserImportRow{
+				{Line: 1, User: UserCreate{Name: "Mario", Email: "mario@example.com", Phone: 3331234567}},
+				{Line: 3, User: UserCreate{Name: "Anna", Phone: 39123}},
+			},
+		},
+		{
+			name:   "jsonl invalid row",
+			format: UserImportFormatJSONL,
+			input:  "{\"name\":\"Mario\"\n{\"name\":\"Anna\",\"phone\":-1}\n",
+			want: []UserImportRow{
+				{Line: 2, User: UserCreate{Name: "Anna"}},
+			},
+			errs: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			src, err := NewUserImportSource(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rows []UserImportRow
+			var errs []int
+			for {
+				row, err := src.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if row.Err != nil {
+					if ErrorCode(row.Err) != EINVALID {
+						t.Errorf("line %d: error code = %q, want %q", row.Line, ErrorCode(row.Err), EINVALID)
+					}
+					errs = append(errs, row.Line)
+					continue
+				}
+				rows = append(rows, row)
+			}
+
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("rows = %+v, want %+v", rows, tt.want)
+			}
+			if !reflect.DeepEqual(errs, tt.errs) {
+				t.Errorf("error lines = %v, want %v", errs, tt.errs)
+			}
+		})
+	}
+}
+
+func TestNewUserImportSourceErrors(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"unknown format", "xml", ""},
+		{"empty csv", UserImportFormatCSV, ""},
+		{"missing column", UserImportFormatCSV, "name,surname,email,password\n"},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewUserImportSource(strings.NewReader(tt.input), tt.format); ErrorCode(err) != EINVALID {
+			t.Errorf("%s: error = %v, want %s", tt.name, err, EINVALID)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	common "prova/common"
+)
+
+// Stati di una consegna webhook.
+const (
+	WebhookDeliveryStatusPending   = "pending"
+	WebhookDeliveryStatusSucceeded = "succeeded"
+	WebhookDeliveryStatusFailed    = "failed"
+)
+
+// Webhook rappresenta un endpoint esterno che riceve gli eventi di dominio.
+type Webhook struct {
+	ID  int64  `json:"id"`
+	URL string `json:"url"`
+	// Secret firma i payload, è restituito dalle API solo alla creazione del webhook.
+	Secret string `json:"-"`
+	// Events filtra i tipi di evento inviati, se vuoto vengono inviati tutti gli eventi.
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (w Webhook) Validate() error {
+
+	if u, err := url.ParseRequestURI(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Errorf(EINVALID, "URL is invalid")
+	}
+
+	if w.Secret == "" {
+		return Errorf(EINVALID, "Secret is required")
+	}
+
+	for _, e := range w.Events {
+		if !IsValidEventType(e) {
+			return Errorf(EINVALID, "Event %q is invalid", e)
+		}
+	}
+
+	return nil
+}
+
+// Accepts returns true if the webhook should receive events of the given type.
+func (w Webhook) Accepts(eventType string) bool {
+
+	if !w.Active {
+		return false
+	} else if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, e := range w.Events {
+		if e == eventType || e == EventTypeAll {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookDelivery rappresenta l'invio di un evento ad un webhook, usato anche come log delle consegne.
+type WebhookDelivery struct {
+	ID        int64           `json:"id"`
+	WebhookID int64           `json:"webhook_id"`
+	EventID   int64           `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	// ResponseStatus is the HTTP status code of the last attempt, 0 if no response was received.
+	ResponseStatus int        `json:"response_status"`
+	LastError      string     `json:"last_error"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+type WebhookService interface {
+	// CreateWebhook registra un nuovo webhook.
+	CreateWebhook(ctx context.Context, crt WebhookCreate) (*Webhook, error)
+	// DeleteWebhook elimina un webhook e le sue consegne.
+	DeleteWebhook(ctx context.Context, id int64) error
+	// FindWebhookByID cerca un webhook per ID.
+	FindWebhookByID(ctx context.Context, id int64) (*Webhook, error)
+	// FindWebhooks cerca i webhook, restituisce il numero totale di risultati al netto della paginazione.
+	FindWebhooks(ctx context.Context, filter WebhookFilter) ([]*Webhook, int, error)
+	// UpdateWebhook aggiorna un webhook.
+	UpdateWebhook(ctx context.Context, id int64, upd WebhookUpdate) (*Webhook, error)
+	// FindWebhookDeliveries cerca le consegne dei webhook.
+	FindWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]*WebhookDelivery, int, error)
+}
+
+// WebhookSender si occupa di inviare fisicamente una consegna all'endpoint del webhook.
+type WebhookSender interface {
+	// SendWebhook invia la consegna e restituisce lo status code della risposta.
+	SendWebhook(ctx context.Context, w *Webhook, d *WebhookDelivery) (int, error)
+}
+
+type WebhookCreate struct {
+	URL string `json:"url"`
+	// Secret is used to sign payloads, if empty a random secret is generated.
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+type WebhookUpdate struct {
+	URL    common.Patch[string]   `json:"url"`
+	Secret common.Patch[string]   `json:"secret"`
+	Events common.Patch[[]string] `json:"events"`
+	Active common.Patch[bool]     `json:"active"`
+}
+
+type WebhookFilter struct {
+	ID     *int64 `json:"id"`
+	Active *bool  `json:"active"`
+
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+}
+
+type WebhookDeliveryFilter struct {
+	WebhookID *int64  `json:"webhook_id"`
+	Status    *string `json:"status"`
+
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+}
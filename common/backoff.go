@@ -0,0 +1,22 @@
+package common
+
+import "time"
+
+// ExponentialBackoff returns the delay before the given retry attempt (starting from 1),
+// doubling min at every attempt without exceeding max.
+func ExponentialBackoff(attempt int, min, max time.Duration) time.Duration {
+
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	d := min
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+
+	return d
+}
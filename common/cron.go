@@ -0,0 +1,164 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule definisce una schedulazione in formato cron standard a 5 campi:
+// minuto, ora, giorno del mese, mese, giorno della settimana.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar & dowStar are true when the field is "*", if both day fields are restricted
+	// the schedule matches when either matches, as in the standard cron.
+	domStar, dowStar bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a 5 fields cron expression, ranges (1-5), steps (*/15) and lists (1,15) are supported
+// as well as the @hourly, @daily, @weekly, @monthly and @yearly macros.
+func ParseCron(spec string) (CronSchedule, error) {
+
+	if v, ok := cronMacros[strings.TrimSpace(spec)]; ok {
+		spec = v
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return CronSchedule{}, fmt.Errorf("cron expression %q must have 5 fields", spec)
+	}
+
+	var s CronSchedule
+	var err error
+
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return CronSchedule{}, err
+	} else if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return CronSchedule{}, err
+	} else if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return CronSchedule{}, err
+	} else if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return CronSchedule{}, err
+	} else if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return CronSchedule{}, err
+	}
+
+	// sunday can be both 0 and 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domStar, s.dowStar = fields[2] == "*", fields[4] == "*"
+
+	return s, nil
+}
+
+// parseCronField restituisce il bitset dei valori accettati dal campo.
+func parseCronField(field string, min, max int) (uint64, error) {
+
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+
+		rng, step := part, 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in cron field %q", field)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+
+		if rng != "*" {
+			var err error
+			if i := strings.Index(rng, "-"); i >= 0 {
+				if lo, err = strconv.Atoi(rng[:i]); err != nil {
+					return 0, fmt.Errorf("invalid range in cron field %q", field)
+				} else if hi, err = strconv.Atoi(rng[i+1:]); err != nil {
+					return 0, fmt.Errorf("invalid range in cron field %q", field)
+				}
+			} else if lo, err = strconv.Atoi(rng); err != nil {
+				return 0, fmt.Errorf("invalid value in cron field %q", field)
+			} else if step > 1 {
+				// "5/15" means from 5 to the max every 15.
+				hi = max
+			} else {
+				hi = lo
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("cron field %q out of range %d-%d", field, min, max)
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+// Matches returns true if the schedule fires in the minute of t.
+func (s CronSchedule) Matches(t time.Time) bool {
+	return s.minute&(1<<uint(t.Minute())) != 0 &&
+		s.hour&(1<<uint(t.Hour())) != 0 &&
+		s.month&(1<<uint(t.Month())) != 0 &&
+		s.matchesDay(t)
+}
+
+// Next returns the first minute after t in which the schedule fires,
+// the zero time is returned if the schedule never fires (e.g. 30th of February).
+func (s CronSchedule) Next(t time.Time) time.Time {
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// five years are enough to find any valid date, leap days included.
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.Matches(t) {
+			if s.hour&(1<<uint(t.Hour())) == 0 || !s.matchesDay(t) {
+				t = t.Truncate(time.Hour).Add(time.Hour)
+			} else {
+				t = t.Add(time.Minute)
+			}
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matchesDay returns true if the day of t is accepted by the day of month & day of week fields.
+func (s CronSchedule) matchesDay(t time.Time) bool {
+
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"* * * * *", true},
+		{"0 3 * * *", true},
+		{"*/15 0-6 1,15 * 1-5", true},
+		{"5/20 * * * *", true},
+		{"0 0 * * 7", true},
+		{"@daily", true},
+		{" @hourly ", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"1-a * * * *", false},
+		{"@reboot", false},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseCron(tt.spec); (err == nil) != tt.ok {
+			t.Errorf("ParseCron(%q) error = %v, want ok %v", tt.spec, err, tt.ok)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+
+	date := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"* * * * *", "2024-01-01 10:00", "2024-01-01 10:01"},
+		{"0 3 * * *", "2024-01-01 03:00", "2024-01-02 03:00"},
+		{"0 3 * * *", "2024-01-01 02:59", "2024-01-01 03:00"},
+		{"*/15 * * * *", "2024-01-01 10:07", "2024-01-01 10:15"},
+		{"5/20 * * * *", "2024-01-01 10:26", "2024-01-01 10:45"},
+		{"30 9 * * 1-5", "2024-01-05 10:00", "2024-01-08 09:30"}, // friday to monday
+		{"0 0 * * 7", "2024-01-01 00:00", "2024-01-07 00:00"},    // sunday as 7
+		{"0 0 1 * *", "2024-01-31 12:00", "2024-02-01 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"0 0 13 * 5", "2024-01-01 00:00", "2024-01-05 00:00"}, // day of month or friday
+		{"@yearly", "2024-06-01 00:00", "2025-01-01 00:00"},
+		{"0 0 30 2 *", "2024-01-01 00:00", ""},
+	}
+
+	for _, tt := range tests {
+
+		s, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.spec, err)
+		}
+
+		var want time.Time
+		if tt.want != "" {
+			want = date(tt.want)
+		}
+
+		if got := s.Next(date(tt.from)); !got.Equal(want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tt.spec, tt.from, got, want)
+		}
+	}
+}
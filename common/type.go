@@ -6,6 +6,8 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
 	"time"
 )
 
@@ -47,6 +49,59 @@ func (v *Patch[T]) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// IsSet implements Patcher.
+func (v Patch[T]) IsSet() bool {
+	return v.Set
+}
+
+// PatchValue implements Patcher.
+func (v Patch[T]) PatchValue() any {
+	return v.Value
+}
+
+// Patcher è implementato da Patch[T], permette di leggere un campo Patch senza conoscerne il tipo.
+type Patcher interface {
+	IsSet() bool
+	PatchValue() any
+}
+
+// ApplyPatch copia in dst, puntatore ad una struct, i valori dei campi Patch impostati di patch,
+// ogni campo è copiato nel campo di dst con lo stesso nome.
+func ApplyPatch(dst any, patch any) error {
+
+	d, p := reflect.ValueOf(dst), reflect.ValueOf(patch)
+	if d.Kind() != reflect.Pointer || d.Elem().Kind() != reflect.Struct || p.Kind() != reflect.Struct {
+		return fmt.Errorf("apply patch: dst must be a pointer to struct and patch a struct")
+	}
+	d = d.Elem()
+
+	for i := 0; i < p.NumField(); i++ {
+
+		v, ok := p.Field(i).Interface().(Patcher)
+		if !ok || !v.IsSet() {
+			continue
+		}
+
+		name := p.Type().Field(i).Name
+
+		f := d.FieldByName(name)
+		if !f.IsValid() || !f.CanSet() {
+			return fmt.Errorf("apply patch: field %s not found", name)
+		}
+
+		value := reflect.ValueOf(v.PatchValue())
+		if !value.IsValid() {
+			f.Set(reflect.Zero(f.Type()))
+		} else if !value.Type().AssignableTo(f.Type()) {
+			return fmt.Errorf("apply patch: field %s has type %s, not %s", name, f.Type(), value.Type())
+		} else {
+			f.Set(value)
+		}
+	}
+
+	return nil
+}
+
 // NewPatch restituisce una nuova istanza di NewPatch.
 func NewPatch[T any](val T) Patch[T] {
 	return Patch[T]{
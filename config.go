@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"prova/app"
+	"prova/storage"
+
+	"github.com/caarlos0/env/v6"
+)
+
+// Config contiene la configurazione dell'applicazione letta dalle variabili d'ambiente.
+type Config struct {
+	Port        string `env:"PORT" envDefault:"5000"`
+	BaseURL     string `env:"BASE_URL"`
+	PostgresURL string `env:"POSTGRES_URL"`
+
+	// JobWorkers is the number of background jobs executed concurrently.
+	JobWorkers int `env:"JOB_WORKERS" envDefault:"4"`
+	// JobMaxAttempts is the number of attempts after which a job is dead-lettered.
+	JobMaxAttempts int `env:"JOB_MAX_ATTEMPTS" envDefault:"5"`
+	// JobDrainTimeout is the time given to running jobs on shutdown.
+	JobDrainTimeout time.Duration `env:"JOB_DRAIN_TIMEOUT" envDefault:"30s"`
+
+	// MaintenanceRetention is how long processed events, jobs and webhook deliveries are kept.
+	MaintenanceRetention time.Duration `env:"MAINTENANCE_RETENTION" envDefault:"720h"`
+	// SoftDeleteRetention is how long deleted users and admins are kept before being purged.
+	SoftDeleteRetention time.Duration `env:"SOFT_DELETE_RETENTION" envDefault:"2160h"`
+
+	// SessionSecret signs the cookies of the admin panel, sessions don't survive a restart when empty.
+	SessionSecret string `env:"SESSION_SECRET"`
+
+	// TrustedProxies are the comma separated CIDRs of the proxies whose X-Forwarded-For header is trusted.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:","`
+
+	// DevMode reloads the templates from TemplatesDir when they change and shows their errors in the browser.
+	DevMode      bool   `env:"DEV_MODE"`
+	TemplatesDir string `env:"TEMPLATES_DIR" envDefault:"http/views"`
+
+	// StorageBackend is where uploaded files are saved, local or s3.
+	StorageBackend string `env:"STORAGE_BACKEND" envDefault:"local"`
+	// StorageDir is the directory of the local backend.
+	StorageDir string `env:"STORAGE_DIR" envDefault:"data/files"`
+
+	// S3 settings, S3Endpoint can point to a local MinIO with S3PathStyle.
+	S3Endpoint        string `env:"S3_ENDPOINT" envDefault:"https://s3.amazonaws.com"`
+	S3Region          string `env:"S3_REGION" envDefault:"us-east-1"`
+	S3Bucket          string `env:"S3_BUCKET"`
+	S3AccessKeyID     string `env:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY"`
+	S3PathStyle       bool   `env:"S3_PATH_STYLE"`
+}
+
+// FileStorage restituisce il backend dei file configurato.
+func (cfg Config) FileStorage() (app.FileStorage, error) {
+	switch cfg.StorageBackend {
+	case "local":
+		return storage.NewLocalStorage(cfg.StorageDir), nil
+	case "s3":
+		if cfg.S3Bucket == "" {
+			return nil, app.Errorf(app.EINVALID, "S3_BUCKET is required")
+		}
+		s := storage.NewS3Storage(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKeyID, cfg.S3SecretAccessKey)
+		s.PathStyle = cfg.S3PathStyle
+		return s, nil
+	}
+	return nil, app.Errorf(app.EINVALID, "Invalid storage backend %q", cfg.StorageBackend)
+}
+
+// ParseConfig legge la configurazione dalle variabili d'ambiente.
+func ParseConfig() (Config, error) {
+	var cfg Config
+	if err := env.Parse(&cfg); err != nil {
+		return Config{}, app.Errorf(app.EINVALID, "Error parsing config: %v", err)
+	}
+	return cfg, nil
+}
@@ -2,20 +2,25 @@ module prova
 
 go 1.21.3
 
-require github.com/inconshreveable/log15 v2.16.0+incompatible
+require (
+	github.com/inconshreveable/log15 v2.16.0+incompatible
+	golang.org/x/crypto v0.14.0
+	golang.org/x/image v0.13.0
+)
 
 require (
+	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/labstack/gommon v0.4.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.14.0 // indirect
 	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
+	golang.org/x/time v0.3.0 // indirect
 )
 
 require (
 	github.com/caarlos0/env/v6 v6.10.1
-	github.com/go-stack/stack v1.8.1 // indirect
+	github.com/go-stack/stack v1.8.1
 	github.com/labstack/echo/v4 v4.11.2
 	github.com/lib/pq v1.10.9
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+)
+
+// registerAdminRoutes registra le rotte API per la gestione degli amministratori.
+func (s *ServerAPI) registerAdminRoutes(g *echo.Group) {
+	g.GET("/admins", s.handlerFindAdmins)
+	g.GET("/admins/:id", s.handlerFindAdminByID)
+	g.PATCH("/admins/:id", s.handlerUpdateAdmin)
+}
+
+func (s *ServerAPI) handlerFindAdmins(c echo.Context) error {
+
+	var filter app.AdminFilter
+
+	if v := c.QueryParam("email"); v != "" {
+		filter.Email = &v
+	}
+
+	if v := c.QueryParam("after"); v != "" {
+		filter.After = &v
+	}
+
+	if v := c.QueryParam("before"); v != "" {
+		filter.Before = &v
+	}
+
+	if err := echo.QueryParamsBinder(c).
+		Bool("skip_count", &filter.SkipCount).
+		Bool("deleted", &filter.Deleted).
+		Bool("include_deleted", &filter.IncludeDeleted).
+		String("sort_by", &filter.SortBy).
+		String("sort_dir", &filter.SortDir).
+		Int("page", &filter.Page).
+		Int("limit", &filter.Limit).
+		BindError(); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	admins, n, err := s.AdminService.FindAdmins(c.Request().Context(), filter)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	for _, admin := range admins {
+		admin.Password = ""
+	}
+
+	// with pagination=cursor the first page is requested without after & before.
+	if c.QueryParam("pagination") == "cursor" || filter.After != nil || filter.Before != nil {
+		return SuccessResponseJSON(c, http.StatusOK, NewCursorResponse(admins, n, filter.SkipCount, filter.Limit, filter.After, filter.Before, func(a *app.Admin) string {
+			return a.Cursor(filter.SortBy, filter.SortDir).Encode()
+		}))
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, NewPaginateResponse(admins, n, filter.Page, filter.Limit))
+}
+
+func (s *ServerAPI) handlerFindAdminByID(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	admin, err := s.AdminService.FindAdminByID(c.Request().Context(), id)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	c.Response().Header().Set("ETag", entityETag(admin.ID, admin.Version))
+	admin.Password = ""
+
+	return SuccessResponseJSON(c, http.StatusOK, admin)
+}
+
+func (s *ServerAPI) handlerUpdateAdmin(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	var upd app.AdminUpdate
+
+	if err := c.Bind(&upd); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	// with If-Match the expected version is the current one, a concurrent update between
+	// the read and the write makes the service fail with ECONFLICT.
+	if c.Request().Header.Get("If-Match") != "" {
+
+		admin, err := s.AdminService.FindAdminByID(c.Request().Context(), id)
+		if err != nil {
+			app.LogErr(s.LogService, err)
+			return ErrorResponseJSON(c, err, nil)
+		}
+
+		if !matchesIfMatch(c, entityETag(admin.ID, admin.Version)) {
+			return PreconditionFailedErrorJSON(c)
+		}
+
+		upd.Version = &admin.Version
+	}
+
+	admin, err := s.AdminService.UpdateAdmin(c.Request().Context(), id, upd)
+	if err != nil {
+		// the precondition has failed only if the admin has changed since the check, otherwise the conflict is another one.
+		if app.ErrorCode(err) == app.ECONFLICT && upd.Version != nil {
+			if current, ferr := s.AdminService.FindAdminByID(c.Request().Context(), id); ferr == nil && current.Version != *upd.Version {
+				return PreconditionFailedErrorJSON(c)
+			}
+		}
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	c.Response().Header().Set("ETag", entityETag(admin.ID, admin.Version))
+	admin.Password = ""
+
+	return SuccessResponseJSON(c, http.StatusOK, admin)
+}
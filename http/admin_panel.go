@@ -0,0 +1,351 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// adminUsersPerPage è il numero di user per pagina della tabella del pannello.
+const adminUsersPerPage = 20
+
+// adminUserSortFields sono le colonne della tabella degli user per cui si può ordinare.
+var adminUserSortFields = []string{"id", "name", "surname", "email", "phone", "created_at"}
+
+// registerAdminPanelRoutes registra le pagine del pannello di amministrazione, protette dalla sessione
+// tranne il login. Tutti i form sono protetti dal token CSRF.
+func (s *ServerAPI) registerAdminPanelRoutes(g *echo.Group) {
+
+	// the admin URLs contain searches and user ids, which are not sent to other sites.
+	security := DefaultSecurityConfig()
+	security.ReferrerPolicy = "same-origin"
+	g.Use(s.secureHeaders(security))
+
+	g.Use(middleware.CSRFWithConfig(middleware.CSRFConfig{
+		TokenLookup:    "form:" + csrfFormField,
+		CookieName:     "admin_csrf",
+		CookiePath:     "/admin",
+		CookieHTTPOnly: true,
+		CookieSameSite: http.SameSiteStrictMode,
+		ErrorHandler: func(err error, c echo.Context) error {
+			return s.errorPage(c, app.Errorf(app.EFORBIDDEN, "Session expired, reload the page and try again"))
+		},
+	}))
+
+	g.GET("/login", s.handlerAdminLoginPage)
+	g.POST("/login", s.handlerAdminLogin)
+
+	auth := g.Group("", s.authenticateAdminSession)
+	auth.POST("/logout", s.handlerAdminLogout)
+	auth.GET("", s.handlerAdminHome)
+	auth.GET("/users", s.handlerAdminUsersPage)
+	auth.GET("/users/:id", s.handlerAdminUserPage)
+	auth.POST("/users/:id", s.handlerAdminUpdateUser)
+	auth.GET("/users/:id/delete", s.handlerAdminDeleteUserPage)
+	auth.POST("/users/:id/delete", s.handlerAdminDeleteUser)
+}
+
+// authenticateAdminSession è il middleware che autentica le pagine del pannello tramite il cookie di sessione,
+// senza sessione valida reindirizza al login.
+func (s *ServerAPI) authenticateAdminSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		var sess adminSession
+		if !s.readSignedCookie(c, adminSessionCookie, &sess) || time.Now().Unix() > sess.ExpiresAt {
+			return c.Redirect(http.StatusSeeOther, "/admin/login?next="+url.QueryEscape(c.Request().URL.RequestURI()))
+		}
+
+		// the admin may have been deleted or deactivated after the login.
+		admin, err := s.AdminService.FindAdminByID(c.Request().Context(), sess.AdminID)
+		if app.ErrorCode(err) == app.ENOTFOUND || (err == nil && !admin.Active) {
+			s.setSignedCookie(c, adminSessionCookie, nil, -1)
+			return c.Redirect(http.StatusSeeOther, "/admin/login")
+		} else if err != nil {
+			app.LogErr(s.LogService, err)
+			return s.errorPage(c, err)
+		}
+
+		c.SetRequest(c.Request().WithContext(app.NewContextWithAdmin(c.Request().Context(), admin)))
+
+		return next(c)
+	}
+}
+
+// adminPageData sono i dati comuni alle pagine del pannello, Page contiene quelli della singola pagina.
+type adminPageData[T any] struct {
+	Admin *app.Admin
+	Flash *flash
+	Page  T
+}
+
+// renderAdminPage effettua il render di una pagina del pannello con l'amministratore e il messaggio flash.
+func renderAdminPage[T any](s *ServerAPI, c echo.Context, httpCode int, name string, title string, data T) error {
+	return s.renderPage(c, httpCode, name, HeadData{Title: title, NoIndex: true}, adminPageData[T]{
+		Admin: app.AdminFromContext(c.Request().Context()),
+		Flash: s.popFlash(c),
+		Page:  data,
+	})
+}
+
+// adminLoginForm sono i dati della pagina di login.
+type adminLoginForm struct {
+	Email string
+	Next  string
+	Error string
+}
+
+func (s *ServerAPI) handlerAdminLoginPage(c echo.Context) error {
+	return renderAdminPage(s, c, http.StatusOK, AdminLoginPage, app.Translate(requestLocale(c), "admin.login.title"), adminLoginForm{Next: c.QueryParam("next")})
+}
+
+func (s *ServerAPI) handlerAdminLogin(c echo.Context) error {
+
+	form := adminLoginForm{Email: c.FormValue("email"), Next: c.FormValue("next")}
+
+	admin, err := s.AdminService.AuthenticateAdmin(c.Request().Context(), form.Email, c.FormValue("password"))
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		form.Error = MessageFromErr(err, requestLocale(c))
+		return renderAdminPage(s, c, StatusCodeFromErr(err), AdminLoginPage, app.Translate(requestLocale(c), "admin.login.title"), form)
+	}
+
+	sess := adminSession{AdminID: admin.ID, ExpiresAt: time.Now().Add(AdminSessionDuration).Unix()}
+	if err := s.setSignedCookie(c, adminSessionCookie, sess, int(AdminSessionDuration.Seconds())); err != nil {
+		app.LogErr(s.LogService, err)
+		return s.errorPage(c, err)
+	}
+
+	// only pages of the panel are allowed, next could otherwise redirect to another site.
+	next := "/admin/users"
+	if strings.HasPrefix(form.Next, "/admin/") && !strings.HasPrefix(form.Next, "//") {
+		next = form.Next
+	}
+
+	return c.Redirect(http.StatusSeeOther, next)
+}
+
+func (s *ServerAPI) handlerAdminLogout(c echo.Context) error {
+	s.setSignedCookie(c, adminSessionCookie, nil, -1)
+	return c.Redirect(http.StatusSeeOther, "/admin/login")
+}
+
+func (s *ServerAPI) handlerAdminHome(c echo.Context) error {
+	return c.Redirect(http.StatusSeeOther, "/admin/users")
+}
+
+// adminUserList sono i dati della tabella degli user, con la ricerca e l'ordinamento correnti.
+type adminUserList struct {
+	PaginateResponse[*app.User]
+	Query   string
+	SortBy  string
+	SortDir string
+}
+
+// TotalPages restituisce il numero di pagine della tabella.
+func (l adminUserList) TotalPages() int {
+	return max(1, (l.TotalResults+l.ItemsPerPage-1)/l.ItemsPerPage)
+}
+
+// Pages restituisce i numeri delle pagine vicine a quella corrente da mostrare nella paginazione.
+func (l adminUserList) Pages() []int {
+	var pages []int
+	for p := max(1, l.CurrentPage-2); p <= min(l.TotalPages(), l.CurrentPage+2); p++ {
+		pages = append(pages, p)
+	}
+	return pages
+}
+
+// PageURL restituisce l'URL della pagina page con la ricerca e l'ordinamento correnti.
+func (l adminUserList) PageURL(page int) string {
+	return l.url(page, l.SortBy, l.SortDir)
+}
+
+// SortURL restituisce l'URL della tabella ordinata per field, invertendo la direzione se è già ordinata per field.
+func (l adminUserList) SortURL(field string) string {
+	dir := "asc"
+	if field == l.SortBy && l.SortDir == "asc" {
+		dir = "desc"
+	}
+	return l.url(1, field, dir)
+}
+
+// SortMark restituisce l'indicatore della direzione se la tabella è ordinata per field.
+func (l adminUserList) SortMark(field string) string {
+	if field != l.SortBy {
+		return ""
+	} else if l.SortDir == "desc" {
+		return "▼"
+	}
+	return "▲"
+}
+
+func (l adminUserList) url(page int, sortBy, sortDir string) string {
+	u, _ := buildURL("/admin/users", "q", l.Query, "sort_by", sortBy, "sort_dir", sortDir, "page", page)
+	return u
+}
+
+func (s *ServerAPI) handlerAdminUsersPage(c echo.Context) error {
+
+	list := adminUserList{
+		Query:   strings.TrimSpace(c.QueryParam("q")),
+		SortBy:  c.QueryParam("sort_by"),
+		SortDir: c.QueryParam("sort_dir"),
+	}
+
+	if !slices.Contains(adminUserSortFields, list.SortBy) {
+		list.SortBy = "id"
+	}
+	if list.SortDir != "desc" {
+		list.SortDir = "asc"
+	}
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	page = max(1, page)
+
+	filter := app.UserFilter{SortBy: list.SortBy, SortDir: list.SortDir, Page: page, Limit: adminUsersPerPage}
+	if list.Query != "" {
+		filter.Search = &list.Query
+	}
+
+	users, n, err := s.UserService.FindUsers(c.Request().Context(), filter)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return s.errorPage(c, err)
+	}
+
+	list.PaginateResponse = NewPaginateResponse(users, n, page, adminUsersPerPage)
+
+	return renderAdminPage(s, c, http.StatusOK, AdminUsersPage, app.Translate(requestLocale(c), "admin.users.title"), list)
+}
+
+// adminUserForm sono i dati del form di modifica di uno user, i valori sono quelli inviati se il salvataggio fallisce.
+type adminUserForm struct {
+	User    *app.User
+	Name    string
+	Surname string
+	Email   string
+	Phone   string
+	Version int64
+	Error   string
+}
+
+// newAdminUserForm restituisce il form con i valori correnti dello user.
+func newAdminUserForm(user *app.User) adminUserForm {
+	return adminUserForm{
+		User:    user,
+		Name:    user.Name,
+		Surname: user.Surname,
+		Email:   user.Email,
+		Phone:   strconv.FormatInt(user.Phone, 10),
+		Version: user.Version,
+	}
+}
+
+// findAdminUser cerca lo user del parametro id.
+func (s *ServerAPI) findAdminUser(c echo.Context) (*app.User, error) {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, app.Errorf(app.ENOTFOUND, "User not found")
+	}
+
+	return s.UserService.FindUserByID(c.Request().Context(), id)
+}
+
+func (s *ServerAPI) handlerAdminUserPage(c echo.Context) error {
+
+	user, err := s.findAdminUser(c)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return s.errorPage(c, err)
+	}
+
+	return renderAdminPage(s, c, http.StatusOK, AdminUserPage, user.Name+" "+user.Surname, newAdminUserForm(user))
+}
+
+func (s *ServerAPI) handlerAdminUpdateUser(c echo.Context) error {
+
+	user, err := s.findAdminUser(c)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return s.errorPage(c, err)
+	}
+
+	form := adminUserForm{
+		User:    user,
+		Name:    strings.TrimSpace(c.FormValue("name")),
+		Surname: strings.TrimSpace(c.FormValue("surname")),
+		Email:   strings.TrimSpace(c.FormValue("email")),
+		Phone:   strings.TrimSpace(c.FormValue("phone")),
+	}
+
+	form.Version, _ = strconv.ParseInt(c.FormValue("version"), 10, 64)
+
+	var upd app.UserUpdate
+	upd.Name.Value, upd.Name.Set = form.Name, true
+	upd.Surname.Value, upd.Surname.Set = form.Surname, true
+	upd.Email.Value, upd.Email.Set = form.Email, true
+	upd.Version = &form.Version
+
+	// an empty password keeps the current one.
+	if v := c.FormValue("password"); v != "" {
+		upd.Password.Value, upd.Password.Set = v, true
+	}
+
+	phone, err := strconv.ParseInt(form.Phone, 10, 64)
+	if err != nil {
+		err = app.Errorf(app.EINVALID, "Phone is invalid")
+	} else {
+		upd.Phone.Value, upd.Phone.Set = phone, true
+		_, err = s.UserService.UpdateUser(c.Request().Context(), user.ID, upd)
+	}
+
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		form.Error = MessageFromErr(err, requestLocale(c))
+		return renderAdminPage(s, c, StatusCodeFromErr(err), AdminUserPage, user.Name+" "+user.Surname, form)
+	}
+
+	s.setFlash(c, flashSuccess, app.Translate(requestLocale(c), "admin.flash.user_updated"))
+
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/admin/users/%d", user.ID))
+}
+
+func (s *ServerAPI) handlerAdminDeleteUserPage(c echo.Context) error {
+
+	user, err := s.findAdminUser(c)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return s.errorPage(c, err)
+	}
+
+	return renderAdminPage(s, c, http.StatusOK, AdminDeleteUserPage, app.Translate(requestLocale(c), "admin.user_delete.title"), user)
+}
+
+func (s *ServerAPI) handlerAdminDeleteUser(c echo.Context) error {
+
+	user, err := s.findAdminUser(c)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return s.errorPage(c, err)
+	}
+
+	if err := s.UserService.DeleteUser(c.Request().Context(), user.ID); err != nil {
+		app.LogErr(s.LogService, err)
+		s.setFlash(c, flashError, MessageFromErr(err, requestLocale(c)))
+		return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/admin/users/%d", user.ID))
+	}
+
+	s.setFlash(c, flashSuccess, app.Translate(requestLocale(c), "admin.flash.user_deleted", user.Name, user.Surname))
+
+	return c.Redirect(http.StatusSeeOther, "/admin/users")
+}
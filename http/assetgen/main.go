@@ -0,0 +1,267 @@
+// Command assetgen prepara gli asset statici serviti da /static. Copia i file della cartella sorgente con
+// l'hash del contenuto nel nome, aggiunge le varianti compresse e scrive il manifest con gli hash SRI:
+//
+//	go run ./assetgen -src assets -dst static
+//
+// I file di terze parti elencati in vendor.json sono scaricati una sola volta nella cartella sorgente e
+// verificati con il loro hash SRI, così le build successive non richiedono la rete. Se il download non
+// riesce il comando fallisce, gli asset sono sempre serviti dal sito. Le varianti brotli sono create solo
+// se il comando brotli è installato, quelle gzip sempre.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// vendorFile è un file di terze parti da scaricare.
+type vendorFile struct {
+	Path      string `json:"path"`
+	URL       string `json:"url"`
+	Integrity string `json:"integrity"`
+}
+
+// asset è una voce del manifest, Path è il percorso con l'hash in dst.
+type asset struct {
+	Path      string   `json:"path"`
+	Integrity string   `json:"integrity"`
+	Encodings []string `json:"encodings,omitempty"`
+}
+
+const (
+	vendorList   = "vendor.json"
+	manifestFile = "manifest.json"
+
+	// minCompressSize è la dimensione sotto la quale la compressione non conviene.
+	minCompressSize = 1024
+)
+
+// compressible sono le estensioni dei file di testo di cui creare le varianti compresse.
+var compressible = map[string]bool{
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".map":  true,
+	".svg":  true,
+	".txt":  true,
+}
+
+func main() {
+
+	src := flag.String("src", "assets", "source directory")
+	dst := flag.String("dst", "static", "output directory, it is emptied before writing the assets")
+	flag.Parse()
+
+	if err := run(*src, *dst); err != nil {
+		fmt.Fprintln(os.Stderr, "assetgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(src, dst string) error {
+
+	if err := vendor(src); err != nil {
+		return err
+	}
+
+	manifest := map[string]asset{}
+
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+
+	brotli, _ := exec.LookPath("brotli")
+	if brotli == "" {
+		fmt.Fprintln(os.Stderr, "assetgen: brotli not found, skipping the brotli variants")
+	}
+
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		name, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		if name == vendorList {
+			return nil
+		}
+
+		a, err := build(p, dst, name, brotli)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		manifest[name] = a
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dst, manifestFile), append(b, '\n'), 0o644)
+}
+
+// vendor scarica i file di terze parti che mancano in src.
+func vendor(src string) error {
+
+	b, err := os.ReadFile(filepath.Join(src, vendorList))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var files []vendorFile
+	if err := json.Unmarshal(b, &files); err != nil {
+		return fmt.Errorf("%s: %w", vendorList, err)
+	}
+
+	for _, f := range files {
+
+		p := filepath.Join(src, filepath.FromSlash(f.Path))
+
+		if _, err := os.Stat(p); err == nil {
+			continue
+		}
+
+		data, err := download(f.URL)
+		if err != nil {
+			return fmt.Errorf("%s is not vendored and can't be downloaded from %s: %w", f.Path, f.URL, err)
+		}
+
+		if got := integrity(data); got != f.Integrity {
+			return fmt.Errorf("%s: integrity mismatch, expected %s, got %s", f.URL, f.Integrity, got)
+		}
+
+		if err := writeFile(p, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func download(url string) ([]byte, error) {
+
+	client := http.Client{Timeout: 30 * time.Second}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// build scrive in dst il file p con l'hash nel nome e le sue varianti compresse.
+func build(p, dst, name, brotli string) (asset, error) {
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return asset{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	ext := path.Ext(name)
+
+	a := asset{
+		Path:      strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext,
+		Integrity: integrity(data),
+	}
+
+	out := filepath.Join(dst, filepath.FromSlash(a.Path))
+	if err := writeFile(out, data); err != nil {
+		return asset{}, err
+	}
+
+	if !compressible[ext] || len(data) < minCompressSize {
+		return a, nil
+	}
+
+	// the encodings are in order of preference.
+	if brotli != "" {
+		if err := exec.Command(brotli, "-q", "11", "-f", "-o", out+".br", out).Run(); err != nil {
+			return asset{}, fmt.Errorf("brotli: %w", err)
+		}
+		if ok, err := keepSmaller(out+".br", len(data)); err != nil {
+			return asset{}, err
+		} else if ok {
+			a.Encodings = append(a.Encodings, "br")
+		}
+	}
+
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if _, err := zw.Write(data); err != nil {
+		return asset{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return asset{}, err
+	}
+	if buf.Len() < len(data) {
+		if err := writeFile(out+".gz", buf.Bytes()); err != nil {
+			return asset{}, err
+		}
+		a.Encodings = append(a.Encodings, "gzip")
+	}
+
+	return a, nil
+}
+
+// keepSmaller rimuove la variante compressa p se non è più piccola dell'originale.
+func keepSmaller(p string, size int) (bool, error) {
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return false, err
+	}
+
+	if fi.Size() < int64(size) {
+		return true, nil
+	}
+
+	return false, os.Remove(p)
+}
+
+// integrity restituisce l'hash SRI sha384 di data.
+func integrity(data []byte) string {
+	sum := sha512.Sum384(data)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func writeFile(p string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
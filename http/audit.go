@@ -0,0 +1,54 @@
+package http
+
+import (
+	"net/http"
+
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+)
+
+// registerAuditLogRoutes registra le rotte per la consultazione dell'audit log.
+func (s *ServerAPI) registerAuditLogRoutes(g *echo.Group) {
+	g.GET("/audit-logs", s.handlerFindAuditLogs)
+}
+
+func (s *ServerAPI) handlerFindAuditLogs(c echo.Context) error {
+
+	var filter app.AuditLogFilter
+
+	if v := c.QueryParam("action"); v != "" {
+		filter.Action = &v
+	}
+
+	if v := c.QueryParam("entity_type"); v != "" {
+		filter.EntityType = &v
+	}
+
+	var actorID, entityID int64
+
+	if err := echo.QueryParamsBinder(c).
+		Int64("actor_id", &actorID).
+		Int64("entity_id", &entityID).
+		Int("page", &filter.Page).
+		Int("limit", &filter.Limit).
+		BindError(); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	if c.QueryParam("actor_id") != "" {
+		filter.ActorID = &actorID
+	}
+
+	if c.QueryParam("entity_id") != "" {
+		filter.EntityID = &entityID
+	}
+
+	logs, n, err := s.AuditLogService.FindAuditLogs(c.Request().Context(), filter)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, NewPaginateResponse(logs, n, filter.Page, filter.Limit))
+}
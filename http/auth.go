@@ -0,0 +1,55 @@
+package http
+
+import (
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+)
+
+// authenticateAdmin è il middleware che autentica le richieste API degli amministratori tramite HTTP Basic auth,
+// l'amministratore autenticato viene salvato nel context della richiesta.
+func (s *ServerAPI) authenticateAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		email, password, ok := c.Request().BasicAuth()
+		if !ok {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="admin"`)
+			return ErrorResponseJSON(c, app.Errorf(app.ENOTAUTHENTICATED, "Authentication required"), nil)
+		}
+
+		admin, err := s.AdminService.AuthenticateAdmin(c.Request().Context(), email, password)
+		if err != nil {
+			app.LogErr(s.LogService, err)
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="admin"`)
+			return ErrorResponseJSON(c, err, nil)
+		}
+
+		c.SetRequest(c.Request().WithContext(app.NewContextWithAdmin(c.Request().Context(), admin)))
+
+		return next(c)
+	}
+}
+
+// authenticateUser è il middleware che autentica le richieste API degli user tramite HTTP Basic auth,
+// lo user autenticato viene salvato nel context della richiesta.
+func (s *ServerAPI) authenticateUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		email, password, ok := c.Request().BasicAuth()
+		if !ok {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="user"`)
+			return ErrorResponseJSON(c, app.Errorf(app.ENOTAUTHENTICATED, "Authentication required"), nil)
+		}
+
+		user, err := s.UserService.AuthenticateUser(c.Request().Context(), email, password)
+		if err != nil {
+			app.LogErr(s.LogService, err)
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="user"`)
+			return ErrorResponseJSON(c, err, nil)
+		}
+
+		c.SetRequest(c.Request().WithContext(app.NewContextWithUser(c.Request().Context(), user)))
+
+		return next(c)
+	}
+}
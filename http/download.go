@@ -0,0 +1,154 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DownloadFileConfig definisce i parametri per far scaricare un file.
+type DownloadFileConfig struct {
+	Filename   string
+	MimeType   string
+	StatusCode *int   // default 200
+	MaxAge     *int64 // default int64((30 * 24 * time.Hour).Seconds())
+
+	// Attachment fa salvare il file invece di mostrarlo nel browser.
+	Attachment bool
+	// ETag è l'ETag forte del contenuto, se vuoto è calcolato dal contenuto quando è possibile rileggerlo.
+	ETag string
+	// LastModified è la data di modifica del contenuto, ignorata se zero.
+	LastModified time.Time
+}
+
+// Validate si occupa di validare le impostazioni passate.
+func (opt DownloadFileConfig) Validate() error {
+
+	if opt.Filename == "" {
+		return app.Errorf(app.EINTERNAL_INVALID, "Filename is required")
+	}
+
+	if opt.MimeType == "" {
+		return app.Errorf(app.EINTERNAL_INVALID, "MimeType is required")
+	}
+
+	return nil
+}
+
+// DownloadFile si occupa di scrivere nella response i campi per permettere di scaricar un file.
+//
+// Se r è un io.ReadSeeker, come bytes.Reader o os.File, la risposta gestisce le richieste Range,
+// anche multiple, e le richieste condizionali con ETag e LastModified, così un download interrotto
+// può essere ripreso. Negli altri casi il contenuto è copiato da r man mano, senza Range.
+func DownloadFile(c echo.Context, r io.Reader, opt DownloadFileConfig) error {
+
+	if err := opt.Validate(); err != nil {
+		return err
+	}
+
+	statusCode := http.StatusOK
+	maxAge := int64((30 * 24 * time.Hour).Seconds())
+
+	if opt.StatusCode != nil {
+		statusCode = *opt.StatusCode
+	}
+	if opt.MaxAge != nil {
+		maxAge = *opt.MaxAge
+	}
+
+	disposition := "inline"
+	if opt.Attachment {
+		disposition = "attachment"
+	}
+
+	rs, seekable := r.(io.ReadSeeker)
+
+	etag := opt.ETag
+	if etag != "" && !strings.HasPrefix(etag, `"`) {
+		etag = strconv.Quote(etag)
+	} else if etag == "" && seekable {
+		var err error
+		if etag, err = contentETag(rs); err != nil {
+			return err
+		}
+	}
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentType, opt.MimeType)
+	h.Set(echo.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": opt.Filename}))
+	h.Set(echo.HeaderCacheControl, "private, max-age="+strconv.FormatInt(maxAge, 10))
+	if etag != "" {
+		h.Set("ETag", etag)
+	}
+
+	// ranges & conditional requests only make sense for the content of a successful response.
+	if seekable && statusCode == http.StatusOK {
+		http.ServeContent(c.Response(), c.Request(), opt.Filename, opt.LastModified, rs)
+		return nil
+	}
+
+	if !opt.LastModified.IsZero() {
+		h.Set(echo.HeaderLastModified, opt.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if statusCode == http.StatusOK && notModified(c, etag, opt.LastModified) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	h.Set("Accept-Ranges", "none")
+	if l, ok := r.(interface{ Len() int }); ok {
+		h.Set(echo.HeaderContentLength, strconv.Itoa(l.Len()))
+	}
+	c.Response().WriteHeader(statusCode)
+
+	if _, err := io.Copy(c.Response(), r); err != nil {
+		// the headers have been sent, the error can only be logged.
+		return app.Errorf(app.EINTERNAL, "Error writing file %s: %v", opt.Filename, err)
+	}
+
+	return nil
+}
+
+// contentETag calcola l'ETag forte dall'hash del contenuto, riportando rs all'inizio.
+func contentETag(rs io.ReadSeeker) (string, error) {
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, rs); err != nil {
+		return "", app.Errorf(app.EINTERNAL, "Error hashing file: %v", err)
+	} else if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return "", app.Errorf(app.EINTERNAL, "Error hashing file: %v", err)
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`, nil
+}
+
+// notModified verifica If-None-Match e, se assente, If-Modified-Since rispetto al contenuto.
+func notModified(c echo.Context, etag string, lastModified time.Time) bool {
+
+	if header := c.Request().Header.Get("If-None-Match"); header != "" {
+		for _, v := range strings.Split(header, ",") {
+			// If-None-Match uses the weak comparison.
+			if v = strings.TrimPrefix(strings.TrimSpace(v), "W/"); v == "*" || (etag != "" && v == strings.TrimPrefix(etag, "W/")) {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(c.Request().Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(since)
+}
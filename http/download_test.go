@@ -0,0 +1,54 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestNotModified(t *testing.T) {
+
+	modified := time.Date(2024, 1, 2, 10, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		name         string
+		header       map[string]string
+		etag         string
+		lastModified time.Time
+		want         bool
+	}{
+		{"no conditions", nil, `"abc"`, modified, false},
+		{"etag match", map[string]string{"If-None-Match": `"abc"`}, `"abc"`, modified, true},
+		{"etag mismatch", map[string]string{"If-None-Match": `"def"`}, `"abc"`, modified, false},
+		{"etag in list", map[string]string{"If-None-Match": `"def", "abc"`}, `"abc"`, modified, true},
+		{"weak etag", map[string]string{"If-None-Match": `W/"abc"`}, `"abc"`, modified, true},
+		{"weak content etag", map[string]string{"If-None-Match": `"abc"`}, `W/"abc"`, modified, true},
+		{"star", map[string]string{"If-None-Match": "*"}, `"abc"`, modified, true},
+		{"no content etag", map[string]string{"If-None-Match": `""`}, "", modified, false},
+		{"etag wins over date", map[string]string{"If-None-Match": `"def"`, "If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat)}, `"abc"`, modified, false},
+		{"not modified since", map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, `"abc"`, modified, true},
+		{"modified after", map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, `"abc"`, modified, false},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, `"abc"`, modified, false},
+		{"unknown modification", map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, `"abc"`, time.Time{}, false},
+	}
+
+	e := echo.New()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			c := e.NewContext(req, httptest.NewRecorder())
+
+			if got := notModified(c, tt.etag, tt.lastModified); got != tt.want {
+				t.Errorf("notModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
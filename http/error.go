@@ -1,7 +1,10 @@
 package http
 
 import (
+	"errors"
 	"net/http"
+	"path"
+	"strings"
 
 	"prova/app"
 
@@ -26,18 +29,18 @@ var codes = map[string]int{
 	app.EUNAVAILABLE:      http.StatusServiceUnavailable,
 }
 
-// MessageFromErr returns the message for the given app error.
+// MessageFromErr returns the message for the given app error translated in locale.
 // EINTERNAL & EUNKNOWN message is obscured by HTTP response.
-func MessageFromErr(err error) string {
+func MessageFromErr(err error, locale string) string {
 
 	appErrMessage := app.ErrorMessage(err)
 	appErrCode := app.ErrorCode(err)
 
 	if appErrMessage == "" || appErrCode == app.EINTERNAL || appErrCode == app.EUNKNOWN {
-		return GenericErrorMessage
+		return app.Translate(locale, GenericErrorMessage)
 	}
 
-	return appErrMessage
+	return app.ErrorMessageLocale(err, locale)
 }
 
 // StatusCodeFromErr returns the HTTP status code for the given app error.
@@ -59,11 +62,11 @@ type ErrorAPI struct {
 	Details interface{} `json:"details"`
 }
 
-// NewErrorAPI returns an ErrorAPI instance.
-func NewErrorAPI(err error, details interface{}) *ErrorAPI {
+// NewErrorAPI returns an ErrorAPI instance with the message translated in locale.
+func NewErrorAPI(err error, details interface{}, locale string) *ErrorAPI {
 	e := &ErrorAPI{
 		Code:    app.ErrorCode(err),
-		Message: MessageFromErr(err),
+		Message: MessageFromErr(err, locale),
 		Details: details,
 	}
 	return e
@@ -71,10 +74,127 @@ func NewErrorAPI(err error, details interface{}) *ErrorAPI {
 
 // ErrorResponseJSON returns an HTTP error response with JSON content.
 func ErrorResponseJSON(c echo.Context, err error, details interface{}) error {
-	return c.JSON(StatusCodeFromErr(err), NewErrorAPI(err, details))
+	return c.JSON(StatusCodeFromErr(err), NewErrorAPI(err, details, requestLocale(c)))
 }
 
 // InvalidRequestErrorJSON restituisce un errore JSON standard per una richiesta non valida
 func InvalidRequestErrorJSON(c echo.Context) error {
-	return ErrorResponseJSON(c, app.Errorf(app.EINVALID, "Richiesta non valida"), nil)
+	return ErrorResponseJSON(c, app.Errorf(app.EINVALID, "Invalid request"), nil)
+}
+
+// errorPages sono le pagine d'errore per codice HTTP, gli altri codici usano quella 400 o 500.
+var errorPages = map[int]string{
+	http.StatusBadRequest:          "errors/400",
+	http.StatusUnauthorized:        "errors/401",
+	http.StatusForbidden:           "errors/403",
+	http.StatusNotFound:            "errors/404",
+	http.StatusInternalServerError: "errors/500",
+	http.StatusServiceUnavailable:  "errors/503",
+}
+
+// errorMessages sono i messaggi delle pagine d'errore per codice d'errore dell'app.
+var errorMessages = map[string]string{
+	app.ECONFLICT:         "error.conflict",
+	app.EFORBIDDEN:        "error.forbidden",
+	app.EINVALID:          "error.invalid",
+	app.ENOTFOUND:         "error.not_found",
+	app.ENOTIMPLEMENTED:   "error.not_implemented",
+	app.EUNAUTHORIZED:     "error.unauthorized",
+	app.ENOTAUTHENTICATED: "error.not_authenticated",
+	app.ESHOULDLOGOUT:     "error.not_authenticated",
+	app.EUNAVAILABLE:      "error.unavailable",
+}
+
+// errorPageData sono i dati delle pagine d'errore.
+type errorPageData struct {
+	StatusCode int
+	// Message è la chiave del messaggio scelto in base al codice d'errore dell'app.
+	Message string
+	// Detail è il messaggio dell'errore, vuoto se è interno.
+	Detail string
+	// RequestID è il riferimento da comunicare al supporto.
+	RequestID string
+}
+
+// errorPage restituisce la pagina d'errore HTML per err, con lo stato HTTP corrispondente.
+func (s *ServerAPI) errorPage(c echo.Context, err error) error {
+
+	code := StatusCodeFromErr(err)
+
+	page, ok := errorPages[code]
+	if !ok && code >= 500 {
+		page = errorPages[http.StatusInternalServerError]
+	} else if !ok {
+		page = errorPages[http.StatusBadRequest]
+	}
+
+	data := errorPageData{
+		StatusCode: code,
+		Message:    "error.internal",
+		RequestID:  app.RequestIDFromContext(c.Request().Context()),
+	}
+
+	if key, ok := errorMessages[app.ErrorCode(err)]; ok {
+		data.Message = key
+	}
+
+	// the internal messages are hidden and the errors of echo only repeat the status text.
+	if msg := app.ErrorMessage(err); msg != "" && msg != http.StatusText(code) && code < http.StatusInternalServerError {
+		data.Detail = MessageFromErr(err, requestLocale(c))
+	}
+
+	return s.renderPage(c, code, page, HeadData{
+		Title:   app.Translate(requestLocale(c), "error."+path.Base(page)+".title"),
+		NoIndex: true,
+	}, data)
+}
+
+// httpErrors sono i codici d'errore dell'app per gli stati HTTP degli errori di echo.
+var httpErrors = map[int]string{
+	http.StatusBadRequest:            app.EINVALID,
+	http.StatusUnauthorized:          app.ENOTAUTHENTICATED,
+	http.StatusForbidden:             app.EFORBIDDEN,
+	http.StatusNotFound:              app.ENOTFOUND,
+	http.StatusMethodNotAllowed:      app.ENOTFOUND,
+	http.StatusRequestEntityTooLarge: app.EINVALID,
+	http.StatusServiceUnavailable:    app.EUNAVAILABLE,
+}
+
+// httpErrorHandler gestisce gli errori restituiti dagli handler e quelli di echo, come le rotte inesistenti.
+// Le richieste API ricevono l'errore JSON, le altre la pagina d'errore.
+func (s *ServerAPI) httpErrorHandler(err error, c echo.Context) {
+
+	if c.Response().Committed {
+		return
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		code, ok := httpErrors[he.Code]
+		if !ok {
+			code = app.EINTERNAL
+		}
+		msg, _ := he.Message.(string)
+		if msg == "" {
+			msg = http.StatusText(he.Code)
+		}
+		// the message of echo is the translation key, unless it can't be used as format.
+		if strings.Contains(msg, "%") {
+			err = app.Errorf(code, "%s", msg)
+		} else {
+			err = app.Errorf(code, msg)
+		}
+	} else {
+		app.LogErr(s.LogService, err)
+	}
+
+	if strings.HasPrefix(c.Request().URL.Path, "/api/") {
+		err = ErrorResponseJSON(c, err, nil)
+	} else {
+		err = s.errorPage(c, err)
+	}
+
+	if err != nil {
+		app.LogErr(s.LogService, err)
+	}
 }
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+)
+
+// entityETag restituisce l'ETag forte di un'entità versionata.
+func entityETag(id, version int64) string {
+	return fmt.Sprintf(`"%d-%d"`, id, version)
+}
+
+// matchesIfMatch verifica l'header If-Match della richiesta rispetto all'ETag corrente,
+// restituisce true anche se l'header non è presente.
+func matchesIfMatch(c echo.Context, etag string) bool {
+
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, v := range strings.Split(header, ",") {
+		if v = strings.TrimSpace(v); v == "*" || v == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PreconditionFailedErrorJSON restituisce l'errore JSON per un If-Match che non corrisponde alla versione corrente.
+func PreconditionFailedErrorJSON(c echo.Context) error {
+	return c.JSON(http.StatusPreconditionFailed, NewErrorAPI(app.Errorf(app.ECONFLICT, "The resource has been modified"), nil, requestLocale(c)))
+}
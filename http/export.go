@@ -0,0 +1,212 @@
+package http
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"prova/app"
+)
+
+// Formati accettati dagli export.
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatXLSX = "xlsx"
+)
+
+// exportTimeLayout è il formato delle date negli export.
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+// tableWriter scrive le righe di una tabella man mano, l'intestazione è scritta con la prima riga
+// oppure dalla Close se non ci sono righe.
+type tableWriter interface {
+	Write(row []any) error
+	Close() error
+}
+
+// newTableWriter restituisce il writer per format con il mime type e l'estensione del file prodotto.
+func newTableWriter(w io.Writer, format string, header []string) (_ tableWriter, mimeType, ext string, err error) {
+	switch format {
+	case "", ExportFormatCSV:
+		return &csvTableWriter{w: csv.NewWriter(w), header: header}, "text/csv; charset=utf-8", ExportFormatCSV, nil
+	case ExportFormatXLSX:
+		return &xlsxTableWriter{zw: zip.NewWriter(w), header: header}, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ExportFormatXLSX, nil
+	}
+	return nil, "", "", app.Errorf(app.EINVALID, "Invalid export format %q", format)
+}
+
+// formatExportCell formatta un valore come testo.
+func formatExportCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		return v.UTC().Format(exportTimeLayout)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.UTC().Format(exportTimeLayout)
+	}
+	return fmt.Sprint(v)
+}
+
+// csvTableWriter scrive la tabella in CSV.
+type csvTableWriter struct {
+	w      *csv.Writer
+	header []string
+	wrote  bool
+}
+
+// Write implements tableWriter.
+func (t *csvTableWriter) Write(row []any) error {
+
+	if err := t.writeHeader(); err != nil {
+		return err
+	}
+
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = formatExportCell(v)
+		// a text starting with a formula character would be evaluated by spreadsheets opening the file.
+		if _, ok := v.(string); ok && record[i] != "" && strings.ContainsRune("=+-@\t\r", rune(record[i][0])) {
+			record[i] = "'" + record[i]
+		}
+	}
+
+	return t.w.Write(record)
+}
+
+// Close implements tableWriter.
+func (t *csvTableWriter) Close() error {
+	if err := t.writeHeader(); err != nil {
+		return err
+	}
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func (t *csvTableWriter) writeHeader() error {
+	if t.wrote {
+		return nil
+	}
+	t.wrote = true
+	return t.w.Write(t.header)
+}
+
+// xlsxTableWriter scrive la tabella come foglio XLSX. Il file è uno zip scritto in streaming, le stringhe
+// sono inline nelle celle così il foglio non richiede la tabella delle stringhe condivise.
+type xlsxTableWriter struct {
+	zw     *zip.Writer
+	sheet  *bufio.Writer
+	header []string
+	rows   int
+}
+
+// xlsxParts sono le parti fisse del file, il foglio xl/worksheets/sheet1.xml è scritto per ultimo.
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// Write implements tableWriter.
+func (t *xlsxTableWriter) Write(row []any) error {
+
+	if err := t.open(); err != nil {
+		return err
+	}
+
+	t.rows++
+	fmt.Fprintf(t.sheet, `<row r="%d">`, t.rows)
+
+	for _, v := range row {
+		switch v := v.(type) {
+		case int64:
+			fmt.Fprintf(t.sheet, `<c t="n"><v>%d</v></c>`, v)
+		default:
+			t.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(t.sheet, []byte(formatExportCell(v))); err != nil {
+				return err
+			}
+			t.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+
+	_, err := t.sheet.WriteString(`</row>`)
+	return err
+}
+
+// Close implements tableWriter.
+func (t *xlsxTableWriter) Close() error {
+
+	if err := t.open(); err != nil {
+		return err
+	}
+
+	t.sheet.WriteString(`</sheetData></worksheet>`)
+
+	if err := t.sheet.Flush(); err != nil {
+		return err
+	}
+	return t.zw.Close()
+}
+
+// open scrive le parti fisse del file e l'intestazione del foglio, se non sono già state scritte.
+func (t *xlsxTableWriter) open() error {
+
+	if t.sheet != nil {
+		return nil
+	}
+
+	for _, part := range xlsxParts {
+		w, err := t.zw.Create(part.name)
+		if err != nil {
+			return err
+		} else if _, err := io.WriteString(w, part.content); err != nil {
+			return err
+		}
+	}
+
+	w, err := t.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+
+	t.sheet = bufio.NewWriter(w)
+	t.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]any, len(t.header))
+	for i, h := range t.header {
+		header[i] = h
+	}
+
+	return t.Write(header)
+}
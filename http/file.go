@@ -0,0 +1,211 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxUploadMemory è la parte dei form multipart tenuta in memoria, il resto è scritto in file temporanei.
+const maxUploadMemory = 1 << 20
+
+// registerFileRoutes registra le rotte API per i file, i permessi sono verificati dal FileService
+// in base all'utente autenticato dal gruppo.
+func (s *ServerAPI) registerFileRoutes(g *echo.Group) {
+	g.POST("/files", s.handlerUploadFile)
+	g.GET("/files", s.handlerFindFiles)
+	g.GET("/files/:id", s.handlerFindFileByID)
+	g.GET("/files/:id/content", s.handlerDownloadFile)
+	g.DELETE("/files/:id", s.handlerDeleteFile)
+}
+
+// handlerUploadFile carica un file con un form multipart con i campi file, kind, owner_type e owner_id.
+// Per gli user il proprietario è sempre lo user autenticato. Il MIME type è ricavato dal contenuto,
+// quello dichiarato dal client è ignorato.
+func (s *ServerAPI) handlerUploadFile(c echo.Context) error {
+
+	// the form can't be larger than the biggest file allowed plus its fields.
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, app.MaxFileSize()+maxUploadMemory)
+
+	if err := c.Request().ParseMultipartForm(maxUploadMemory); err != nil {
+		return ErrorResponseJSON(c, app.Errorf(app.EINVALID, "Invalid upload, the file may be too large"), nil)
+	}
+	defer c.Request().MultipartForm.RemoveAll()
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, _ := f.Read(buf)
+	if _, err := f.Seek(0, 0); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	upl := app.FileUpload{
+		OwnerType: c.FormValue("owner_type"),
+		Kind:      c.FormValue("kind"),
+		Filename:  header.Filename,
+		MimeType:  http.DetectContentType(buf[:n]),
+		Size:      header.Size,
+		Body:      f,
+	}
+
+	if user := app.UserFromContext(c.Request().Context()); user != nil {
+		upl.OwnerType, upl.OwnerID = app.FileOwnerUser, user.ID
+	} else if upl.OwnerID, err = strconv.ParseInt(c.FormValue("owner_id"), 10, 64); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	file, err := s.FileService.CreateFile(c.Request().Context(), upl)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	s.setFileURLs(c, file)
+
+	return SuccessResponseJSON(c, http.StatusCreated, file)
+}
+
+func (s *ServerAPI) handlerFindFiles(c echo.Context) error {
+
+	var filter app.FileFilter
+
+	if v := c.QueryParam("owner_type"); v != "" {
+		filter.OwnerType = &v
+	}
+
+	if v := c.QueryParam("kind"); v != "" {
+		filter.Kind = &v
+	}
+
+	if err := echo.QueryParamsBinder(c).
+		Int("page", &filter.Page).
+		Int("limit", &filter.Limit).
+		BindError(); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	if v := c.QueryParam("owner_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return InvalidRequestErrorJSON(c)
+		}
+		filter.OwnerID = &id
+	}
+
+	files, n, err := s.FileService.FindFiles(c.Request().Context(), filter)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	for _, file := range files {
+		s.setFileURLs(c, file)
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, NewPaginateResponse(files, n, filter.Page, filter.Limit))
+}
+
+func (s *ServerAPI) handlerFindFileByID(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	file, err := s.FileService.FindFileByID(c.Request().Context(), id)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	s.setFileURLs(c, file)
+
+	return SuccessResponseJSON(c, http.StatusOK, file)
+}
+
+// handlerDownloadFile scarica il contenuto di un file, i documenti come allegato e le immagini inline.
+func (s *ServerAPI) handlerDownloadFile(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	variant := c.QueryParam("variant")
+
+	file, r, err := s.FileService.OpenFile(c.Request().Context(), id, variant)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+	defer r.Close()
+
+	opt := DownloadFileConfig{
+		Filename:     file.Filename,
+		MimeType:     file.MimeType,
+		Attachment:   file.Kind == app.FileKindDocument,
+		ETag:         file.Checksum,
+		LastModified: file.CreatedAt,
+	}
+
+	if v := file.Variants[variant]; v != nil {
+		ext := path.Ext(file.Filename)
+		opt.Filename = strings.TrimSuffix(file.Filename, ext) + "-" + variant + ext
+		opt.MimeType, opt.ETag = v.MimeType, v.Checksum
+	}
+
+	// the content of an ID changes only when an image is processed, a day is short enough.
+	maxAge := int64(24 * 60 * 60)
+	opt.MaxAge = &maxAge
+
+	if err := DownloadFile(c, r, opt); err != nil {
+		app.LogErr(s.LogService, err)
+	}
+
+	return nil
+}
+
+// setFileURLs imposta gli URL delle varianti del file, relativi al gruppo di rotte della richiesta.
+func (s *ServerAPI) setFileURLs(c echo.Context, file *app.File) {
+
+	prefix := "/api/admin"
+	if app.UserFromContext(c.Request().Context()) != nil {
+		prefix = "/api/user"
+	}
+
+	for name, v := range file.Variants {
+		v.URL = fmt.Sprintf("%s%s/files/%d/content?variant=%s", s.URL(), prefix, file.ID, url.QueryEscape(name))
+	}
+}
+
+func (s *ServerAPI) handlerDeleteFile(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	if err := s.FileService.DeleteFile(c.Request().Context(), id); err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	return SuccessResponseJSON(c, http.StatusNoContent, nil)
+}
@@ -1,7 +1,6 @@
 package http
 
 import (
-	"bytes"
 	"net/http"
 	"strconv"
 
@@ -27,24 +26,16 @@ func (s *ServerAPI) handlerListaPage(c echo.Context) error {
 
 	if _, err := s.UserService.CreateUser(c.Request().Context(), crt); err != nil {
 		app.LogErr(s.LogService, err)
-		return errorPage(c, http.StatusInternalServerError, ErrLoadingPage)
+		return s.errorPage(c, err)
 	}
 
 	users, _, err := s.UserService.FindUsers(c.Request().Context(), app.UserFilter{})
 	if err != nil {
 		app.LogErr(s.LogService, err)
-		return errorPage(c, http.StatusInternalServerError, ErrLoadingPage)
+		return s.errorPage(c, err)
 	}
 
-	var buf bytes.Buffer
-
-	if err := renderPage(&buf, ListaPageTemplate, PageTemplateData[map[string]any]{
-		ContentData: map[string]any{
-			"Users": users,
-		},
-	}); err != nil {
-		app.LogErr(s.LogService, err)
-		return errorPage(c, http.StatusInternalServerError, ErrLoadingPage)
-	}
-	return c.HTML(http.StatusOK, buf.String())
+	return s.renderPage(c, http.StatusOK, ListaPage, HeadData{}, map[string]any{
+		"Users": users,
+	})
 }
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+)
+
+// localeCookie è il cookie con la lingua scelta dall'utente.
+const localeCookie = "locale"
+
+// localize è il middleware che sceglie la lingua della richiesta e la salva nel context. La lingua è
+// quella del prefisso dell'URL, come /it/admin/users, altrimenti quella del cookie e infine la
+// preferita tra quelle dell'header Accept-Language. La lingua del prefisso è salvata nel cookie e le
+// pagine sono reindirizzate al percorso senza prefisso, a cui sono limitati i cookie della sessione e
+// del token CSRF. Per le API il prefisso è solo rimosso dal percorso prima del routing.
+func (s *ServerAPI) localize(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		req := c.Request()
+
+		// the assets are the same in every language.
+		if strings.HasPrefix(req.URL.Path, staticPrefix) {
+			return next(c)
+		}
+
+		locale, rest := localeFromPath(req.URL.Path)
+		if locale != "" {
+			c.SetCookie(&http.Cookie{
+				Name:     localeCookie,
+				Value:    locale,
+				Path:     "/",
+				MaxAge:   365 * 24 * 60 * 60,
+				Secure:   s.UseTLS(),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+
+			if !strings.HasPrefix(rest, "/api/") {
+				u := *req.URL
+				// a path like //host would be a redirect to another site.
+				u.Path, u.RawPath = "/"+strings.TrimLeft(rest, "/"), ""
+				// the other methods are redirected with their body.
+				code := http.StatusTemporaryRedirect
+				if req.Method == http.MethodGet || req.Method == http.MethodHead {
+					code = http.StatusSeeOther
+				}
+				return c.Redirect(code, u.RequestURI())
+			}
+
+			req.URL.Path, req.URL.RawPath = rest, ""
+		} else if cookie, err := c.Cookie(localeCookie); err == nil && app.IsLocale(cookie.Value) {
+			locale = cookie.Value
+		} else {
+			locale = negotiateLocale(req.Header.Get("Accept-Language"))
+		}
+
+		c.Response().Header().Set("Content-Language", locale)
+		c.Response().Header().Add(echo.HeaderVary, "Accept-Language")
+
+		c.SetRequest(req.WithContext(app.NewContextWithLocale(req.Context(), locale)))
+
+		return next(c)
+	}
+}
+
+// localeFromPath restituisce la lingua del prefisso di path e il percorso senza prefisso,
+// una lingua vuota se path non ha il prefisso di una lingua supportata.
+func localeFromPath(path string) (string, string) {
+
+	prefix, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !app.IsLocale(prefix) {
+		return "", path
+	}
+
+	return prefix, "/" + rest
+}
+
+// negotiateLocale restituisce la lingua supportata con la preferenza più alta nell'header Accept-Language,
+// le varianti regionali come it-CH valgono per la lingua. Senza lingue supportate restituisce quella di default.
+func negotiateLocale(header string) string {
+
+	type tag struct {
+		locale string
+		q      float64
+	}
+
+	var tags []tag
+
+	for _, part := range strings.Split(header, ",") {
+
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		locale, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(name)), "-")
+		if q > 0 && app.IsLocale(locale) {
+			tags = append(tags, tag{locale: locale, q: q})
+		}
+	}
+
+	// the stable sort keeps the order of the header between equal preferences.
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	if len(tags) == 0 {
+		return app.DefaultLocale
+	}
+
+	return tags[0].locale
+}
+
+// requestLocale restituisce la lingua della richiesta.
+func requestLocale(c echo.Context) string {
+	return app.LocaleFromContext(c.Request().Context())
+}
+
+// localeURL restituisce l'URL della richiesta corrente nella lingua locale.
+func localeURL(c echo.Context, locale string) string {
+	u := "/" + locale + c.Request().URL.Path
+	if q := c.Request().URL.RawQuery; q != "" {
+		u += "?" + q
+	}
+	return u
+}
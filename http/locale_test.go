@@ -0,0 +1,127 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"prova/app"
+)
+
+func TestNegotiateLocale(t *testing.T) {
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "en"},
+		{"it", "it"},
+		{"it-IT,it;q=0.9,en;q=0.8", "it"},
+		{"IT-it", "it"},
+		{"en-US,it;q=0.9", "en"},
+		{"en;q=0.5,it;q=0.8", "it"},
+		{"it;q=0.8,en;q=0.8", "it"},
+		{"de,fr;q=0.9", "en"},
+		{"de,it;q=0.1", "it"},
+		{"it;q=0,en;q=0.1", "en"},
+		{"it;q=abc,en;q=0.1", "en"},
+		{"*", "en"},
+		{" it ; q=1 ", "it"},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateLocale(tt.header); got != tt.want {
+			t.Errorf("negotiateLocale(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+// adminServiceStub restituisce sempre lo stesso amministratore.
+type adminServiceStub struct {
+	app.AdminService
+	admin *app.Admin
+}
+
+func (s adminServiceStub) FindAdminByID(ctx context.Context, id int64) (*app.Admin, error) {
+	return s.admin, nil
+}
+
+// userServiceStub non trova nessuno user.
+type userServiceStub struct {
+	app.UserService
+}
+
+func (userServiceStub) FindUsers(ctx context.Context, filter app.UserFilter) ([]*app.User, int, error) {
+	return nil, 0, nil
+}
+
+func TestLocaleLinkKeepsAdminSession(t *testing.T) {
+
+	s := NewServerAPI()
+	s.SessionSecret = []byte("secret")
+	s.AdminService = adminServiceStub{admin: &app.Admin{ID: 1, Name: "Mario", Active: true}}
+	s.UserService = userServiceStub{}
+
+	views, err := fs.Sub(viewsFS, "views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.templates, err = NewTemplateRegistry(views); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(s.handler)
+	defer ts.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the session cookie is scoped to /admin, as set by the login.
+	b, _ := json.Marshal(adminSession{AdminID: 1, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	u, _ := url.Parse(ts.URL)
+	jar.SetCookies(u, []*http.Cookie{{Name: adminSessionCookie, Value: payload + "." + s.sign(payload), Path: "/admin"}})
+
+	client := &http.Client{Jar: jar}
+
+	tests := []struct {
+		link   string
+		path   string
+		query  string
+		locale string
+	}{
+		{"/it/admin/users?q=rossi", "/admin/users", "q=rossi", "it"},
+		{"/en/admin/users", "/admin/users", "", "en"},
+		{"/it//admin/users", "/admin/users", "", "it"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+
+			resp, err := client.Get(ts.URL + tt.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if got := resp.Request.URL; got.Path != tt.path || got.RawQuery != tt.query || got.Host != u.Host {
+				t.Errorf("final URL = %s, want %s?%s", got, tt.path, tt.query)
+			}
+			if got := resp.Header.Get("Content-Language"); got != tt.locale {
+				t.Errorf("Content-Language = %q, want %q", got, tt.locale)
+			}
+		})
+	}
+}
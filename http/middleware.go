@@ -0,0 +1,22 @@
+package http
+
+import (
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+)
+
+// requestContext è il middleware che salva nel context della richiesta l'IP del client
+// e il request ID generato dal middleware RequestID.
+func (s *ServerAPI) requestContext(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		ctx := c.Request().Context()
+		ctx = app.NewContextWithRemoteIP(ctx, c.RealIP())
+		ctx = app.NewContextWithRequestID(ctx, c.Response().Header().Get(echo.HeaderXRequestID))
+
+		c.SetRequest(c.Request().WithContext(ctx))
+
+		return next(c)
+	}
+}
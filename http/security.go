@@ -0,0 +1,107 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// cspNonce è il segnaposto della Content-Security-Policy sostituito con il nonce della richiesta.
+const cspNonce = "{nonce}"
+
+// cspNonceKey è la chiave del nonce nel context di echo.
+const cspNonceKey = "csp_nonce"
+
+// SecurityConfig definisce gli header di sicurezza delle risposte, quelli vuoti non sono inviati.
+type SecurityConfig struct {
+	// HSTS è lo Strict-Transport-Security, inviato solo se il server usa TLS.
+	HSTS              string
+	FrameOptions      string
+	ReferrerPolicy    string
+	PermissionsPolicy string
+	// ContentSecurityPolicy può contenere {nonce}, sostituito con un nonce diverso ad ogni richiesta
+	// e disponibile nei template con la funzione nonce.
+	ContentSecurityPolicy string
+}
+
+// DefaultSecurityConfig restituisce la configurazione delle pagine HTML. Script e stili sono ammessi
+// solo dal sito e, quelli inline, con il nonce.
+func DefaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		HSTS:              "max-age=63072000; includeSubDomains",
+		FrameOptions:      "DENY",
+		ReferrerPolicy:    "strict-origin-when-cross-origin",
+		PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+		ContentSecurityPolicy: "default-src 'self'; " +
+			"script-src 'self' 'nonce-" + cspNonce + "'; " +
+			"style-src 'self' 'nonce-" + cspNonce + "'; " +
+			"img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+	}
+}
+
+// APISecurityConfig è la configurazione delle API, che non restituiscono contenuti da mostrare.
+var APISecurityConfig = SecurityConfig{
+	HSTS:                  "max-age=63072000; includeSubDomains",
+	FrameOptions:          "DENY",
+	ReferrerPolicy:        "no-referrer",
+	ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+}
+
+// secureHeaders è il middleware che imposta gli header di sicurezza di config. Quelli di un gruppo
+// sostituiscono quelli impostati dai middleware precedenti.
+func (s *ServerAPI) secureHeaders(config SecurityConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+
+			h := c.Response().Header()
+
+			setHeader := func(key, value string) {
+				if value == "" {
+					h.Del(key)
+				} else {
+					h.Set(key, value)
+				}
+			}
+
+			hsts := config.HSTS
+			if !s.UseTLS() {
+				hsts = ""
+			}
+
+			csp := config.ContentSecurityPolicy
+			if strings.Contains(csp, cspNonce) {
+				nonce, err := newNonce()
+				if err != nil {
+					return err
+				}
+				c.Set(cspNonceKey, nonce)
+				csp = strings.ReplaceAll(csp, cspNonce, nonce)
+			}
+
+			setHeader(echo.HeaderStrictTransportSecurity, hsts)
+			setHeader(echo.HeaderXContentTypeOptions, "nosniff")
+			setHeader(echo.HeaderXFrameOptions, config.FrameOptions)
+			setHeader(echo.HeaderReferrerPolicy, config.ReferrerPolicy)
+			setHeader("Permissions-Policy", config.PermissionsPolicy)
+			setHeader(echo.HeaderContentSecurityPolicy, csp)
+
+			return next(c)
+		}
+	}
+}
+
+// requestNonce restituisce il nonce della Content-Security-Policy della richiesta.
+func requestNonce(c echo.Context) string {
+	nonce, _ := c.Get(cspNonceKey).(string)
+	return nonce
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
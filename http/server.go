@@ -2,15 +2,18 @@ package http
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
+	"io/fs"
 	"net"
 	"net/http"
+	"os"
 	"prova/app"
-	"strconv"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -33,8 +36,27 @@ type ServerAPI struct {
 	Domain string
 	// BaseURL defines a base url to return as public endpoint
 	BaseURL string
+	// TrustedProxies sono le reti (CIDR) dei proxy di cui si accetta l'header X-Forwarded-For,
+	// se vuoto l'IP del client è quello della connessione.
+	TrustedProxies []string
 
-	UserService app.UserService
+	UserService     app.UserService
+	AdminService    app.AdminService
+	WebhookService  app.WebhookService
+	AuditLogService app.AuditLogService
+	FileService     app.FileService
+
+	// SessionSecret è la chiave con cui sono firmati i cookie del pannello di amministrazione,
+	// se vuota ne viene generata una casuale all'avvio.
+	SessionSecret []byte
+
+	// DevMode carica i template da TemplatesDir ricaricandoli quando cambiano, invece di usare quelli
+	// inclusi nel binario, e mostra gli errori dei template nel browser.
+	DevMode      bool
+	TemplatesDir string
+
+	// templates contiene le pagine HTML, caricate da Open.
+	templates *TemplateRegistry
 
 	// loggin service used by HTTP Server.
 	LogService log.Logger
@@ -50,6 +72,11 @@ func NewServerAPI() *ServerAPI {
 	// Set echo as the default HTTP handler.
 	s.server.Handler = s.handler
 
+	s.handler.HTTPErrorHandler = s.httpErrorHandler
+	s.handler.IPExtractor = echo.ExtractIPDirect()
+	s.handler.Pre(s.localize)
+	s.handler.Use(middleware.RequestID(), s.requestContext, s.secureHeaders(DefaultSecurityConfig()))
+
 	s.handler.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "ciao")
 	})
@@ -58,6 +85,23 @@ func NewServerAPI() *ServerAPI {
 
 	s.handler.POST("/lista", s.handlerListaPage)
 
+	s.handler.GET(staticPrefix+"*", s.handlerStatic)
+
+	apiAdmin := s.handler.Group("/api/admin", s.secureHeaders(APISecurityConfig), s.authenticateAdmin)
+	s.registerWebhookRoutes(apiAdmin)
+	s.registerAuditLogRoutes(apiAdmin)
+	s.registerUserRoutes(apiAdmin)
+	s.registerAdminRoutes(apiAdmin)
+	s.registerFileRoutes(apiAdmin)
+
+	apiUser := s.handler.Group("/api/user", s.secureHeaders(APISecurityConfig), s.authenticateUser)
+	s.registerFileRoutes(apiUser)
+
+	s.registerAdminPanelRoutes(s.handler.Group("/admin"))
+
+	// the typeahead is used by the support agents, which authenticate as admins.
+	s.handler.GET("/api/users/search", s.handlerSearchUsers, s.secureHeaders(APISecurityConfig), s.authenticateAdmin)
+
 	return s
 }
 
@@ -77,9 +121,56 @@ func (s *ServerAPI) Port() int {
 	return s.ln.Addr().(*net.TCPAddr).Port
 }
 
+// configureIPExtractor sceglie come ricavare l'IP del client: dall'header X-Forwarded-For solo se la
+// richiesta arriva da uno dei TrustedProxies, altrimenti dalla connessione.
+func (s *ServerAPI) configureIPExtractor() error {
+
+	if len(s.TrustedProxies) == 0 {
+		s.handler.IPExtractor = echo.ExtractIPDirect()
+		return nil
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+
+	for _, proxy := range s.TrustedProxies {
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		options = append(options, echo.TrustIPRange(network))
+	}
+
+	s.handler.IPExtractor = echo.ExtractIPFromXFFHeader(options...)
+
+	return nil
+}
+
 // Open validates the server options and start it on the bind address.
 func (s *ServerAPI) Open() (err error) {
 
+	if s.DevMode {
+		s.templates = NewDevTemplateRegistry(os.DirFS(s.TemplatesDir))
+	} else {
+		views, err := fs.Sub(viewsFS, "views")
+		if err != nil {
+			return err
+		}
+		if s.templates, err = NewTemplateRegistry(views); err != nil {
+			return fmt.Errorf("loading templates: %w", err)
+		}
+	}
+
+	if err := s.configureIPExtractor(); err != nil {
+		return err
+	}
+
+	if len(s.SessionSecret) == 0 {
+		s.SessionSecret = make([]byte, 32)
+		if _, err := rand.Read(s.SessionSecret); err != nil {
+			return err
+		}
+	}
+
 	if s.Domain != "" {
 		s.ln = autocert.NewListener(s.Domain)
 	} else {
@@ -159,51 +250,42 @@ func NewPaginateResponse[T any](data []T, totalResults int, page int, itemsPerPa
 	}
 }
 
-// DownloadFileConfig definisce i parametri per far scaricare un file.
-type DownloadFileConfig struct {
-	Filename   string
-	MimeType   string
-	StatusCode *int   // default 200
-	MaxAge     *int64 // default int64((30 * 24 * time.Hour).Seconds())
+// CursorResponse rappresenta una risposta paginata con cursori keyset, TotalResults è omesso se il conteggio
+// non è stato richiesto.
+type CursorResponse[T any] struct {
+	Data         []T     `json:"data"`
+	NextCursor   *string `json:"next_cursor"`
+	PrevCursor   *string `json:"prev_cursor"`
+	TotalResults *int    `json:"total_results,omitempty"`
+	ItemsPerPage int     `json:"items_per_page"`
 }
 
-// Validate si occupa di validare le impostazioni passate.
-func (opt DownloadFileConfig) Validate() error {
+// NewCursorResponse crea una nuova CursorResponse per la pagina richiesta con i cursori after o before,
+// cursor restituisce il cursore di un elemento.
+func NewCursorResponse[T any](data []T, totalResults int, skipCount bool, itemsPerPage int, after, before *string, cursor func(T) string) CursorResponse[T] {
 
-	if opt.Filename == "" {
-		return app.Errorf(app.EINTERNAL_INVALID, "Nome file obbligatorio")
-	}
+	r := CursorResponse[T]{Data: data, ItemsPerPage: itemsPerPage}
 
-	if opt.MimeType == "" {
-		return app.Errorf(app.EINTERNAL_INVALID, "MimeType obbligatorio")
+	if !skipCount {
+		r.TotalResults = &totalResults
 	}
 
-	return nil
-}
-
-// DownloadFile si occupa di scrivere nella response i campi per permettere di scaricar un file.
-func DownloadFile(c echo.Context, b []byte, opt DownloadFileConfig) error {
-
-	if err := opt.Validate(); err != nil {
-		return err
+	if len(data) == 0 {
+		return r
 	}
 
-	statusCode := http.StatusOK
-	maxAge := int64((30 * 24 * time.Hour).Seconds())
+	// a full page may be followed by other items, a page requested with before is followed at least by the cursor item.
+	full := itemsPerPage > 0 && len(data) == itemsPerPage
 
-	if opt.StatusCode != nil {
-		statusCode = *opt.StatusCode
-	}
-	if opt.MaxAge != nil {
-		maxAge = *opt.MaxAge
+	if full || before != nil {
+		next := cursor(data[len(data)-1])
+		r.NextCursor = &next
 	}
 
-	c.Response().Status = statusCode
-	c.Response().Header().Set(echo.HeaderContentType, opt.MimeType)
-	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("inline; filename=\"%s\"", opt.Filename))
-	c.Response().Header().Set(echo.HeaderContentLength, strconv.Itoa(len(b)))
-	c.Response().Header().Set(echo.HeaderCacheControl, fmt.Sprintf("private; max-age=%d", maxAge))
-	c.Response().Write(b)
+	if after != nil || (before != nil && full) {
+		prev := cursor(data[0])
+		r.PrevCursor = &prev
+	}
 
-	return nil
+	return r
 }
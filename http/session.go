@@ -0,0 +1,113 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Cookie del pannello di amministrazione.
+const (
+	adminSessionCookie = "admin_session"
+	adminFlashCookie   = "admin_flash"
+)
+
+// AdminSessionDuration è la durata della sessione del pannello di amministrazione.
+const AdminSessionDuration = 12 * time.Hour
+
+// adminSession è la sessione di un amministratore autenticato nel pannello.
+type adminSession struct {
+	AdminID   int64 `json:"admin_id"`
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// Tipi dei messaggi flash, corrispondono alle classi degli alert.
+const (
+	flashSuccess = "success"
+	flashError   = "danger"
+)
+
+// flash è un messaggio mostrato una sola volta nella pagina successiva a un redirect.
+type flash struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// setSignedCookie salva v in un cookie firmato con SessionSecret, maxAge negativo elimina il cookie.
+func (s *ServerAPI) setSignedCookie(c echo.Context, name string, v any, maxAge int) error {
+
+	var value string
+
+	if maxAge >= 0 {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		payload := base64.RawURLEncoding.EncodeToString(b)
+		value = payload + "." + s.sign(payload)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/admin",
+		MaxAge:   maxAge,
+		Secure:   s.UseTLS(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// readSignedCookie legge in v il cookie firmato name, restituisce false se manca o la firma non è valida.
+func (s *ServerAPI) readSignedCookie(c echo.Context, name string, v any) bool {
+
+	cookie, err := c.Cookie(name)
+	if err != nil {
+		return false
+	}
+
+	payload, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return false
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(b, v) == nil
+}
+
+// sign restituisce la firma HMAC-SHA256 di payload.
+func (s *ServerAPI) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.SessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setFlash imposta il messaggio flash mostrato dalla prossima pagina.
+func (s *ServerAPI) setFlash(c echo.Context, typ, message string) error {
+	return s.setSignedCookie(c, adminFlashCookie, flash{Type: typ, Message: message}, 60)
+}
+
+// popFlash restituisce ed elimina il messaggio flash, nil se non è impostato.
+func (s *ServerAPI) popFlash(c echo.Context) *flash {
+
+	var f flash
+	if !s.readSignedCookie(c, adminFlashCookie, &f) {
+		return nil
+	}
+
+	s.setSignedCookie(c, adminFlashCookie, nil, -1)
+
+	return &f
+}
@@ -0,0 +1,153 @@
+package http
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:generate go run ./assetgen -src assets -dst static
+
+// staticFS contiene gli asset generati da assetgen a partire dalla cartella assets.
+//
+//go:embed static
+var staticFS embed.FS
+
+// staticPrefix è il percorso da cui sono serviti gli asset.
+const staticPrefix = "/static/"
+
+// staticCacheControl è la cache degli asset, il nome cambia con il contenuto quindi non scadono mai.
+const staticCacheControl = "public, max-age=31536000, immutable"
+
+// encodingExts sono le estensioni delle varianti compresse per Content-Encoding.
+var encodingExts = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// asset è un file del manifest scritto da assetgen, Path è il percorso con l'hash del contenuto in static.
+type asset struct {
+	Path      string   `json:"path"`
+	Integrity string   `json:"integrity"`
+	Encodings []string `json:"encodings"`
+}
+
+// assets sono gli asset per nome del file sorgente, staticFiles gli stessi per percorso con l'hash.
+var (
+	assets      = map[string]asset{}
+	staticFiles = map[string]asset{}
+)
+
+func init() {
+
+	b, err := staticFS.ReadFile("static/manifest.json")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := json.Unmarshal(b, &assets); err != nil {
+		panic(fmt.Sprintf("invalid asset manifest: %v", err))
+	}
+
+	// the assets are served only by the site, the manifests of the old remote fallback must be regenerated.
+	for name, a := range assets {
+		if a.Path == "" {
+			panic(fmt.Sprintf("asset %s has no path, run go generate ./http", name))
+		}
+		staticFiles[a.Path] = a
+	}
+}
+
+// assetURL restituisce l'URL dell'asset name, il percorso del file in assets.
+func assetURL(name string) (string, error) {
+
+	a, ok := assets[name]
+	if !ok {
+		return "", fmt.Errorf("asset %s not found, run go generate ./http", name)
+	}
+
+	return staticPrefix + a.Path, nil
+}
+
+// assetIntegrity restituisce l'hash SRI dell'asset name.
+func assetIntegrity(name string) (string, error) {
+
+	a, ok := assets[name]
+	if !ok {
+		return "", fmt.Errorf("asset %s not found, run go generate ./http", name)
+	}
+
+	return a.Integrity, nil
+}
+
+// handlerStatic serve gli asset con il nome che contiene l'hash, nella variante compressa accettata
+// dal client se presente.
+func (s *ServerAPI) handlerStatic(c echo.Context) error {
+
+	name := c.Param("*")
+
+	a, ok := staticFiles[name]
+	if !ok {
+		return echo.ErrNotFound
+	}
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderCacheControl, staticCacheControl)
+	h.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+
+	file := name
+	if enc := negotiateEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding), a.Encodings); enc != "" {
+		file += encodingExts[enc]
+		h.Set(echo.HeaderContentEncoding, enc)
+	}
+
+	b, err := fs.ReadFile(staticFS, path.Join("static", file))
+	if err != nil {
+		return err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+
+	return c.Blob(http.StatusOK, contentType, b)
+}
+
+// negotiateEncoding restituisce la prima delle encodings accettata nell'header Accept-Encoding,
+// una stringa vuota se il client non ne accetta nessuna.
+func negotiateEncoding(header string, encodings []string) string {
+
+	accepted := map[string]bool{}
+
+	for _, part := range strings.Split(header, ",") {
+
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+
+	for _, enc := range encodings {
+		if accepted[enc] {
+			return enc
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+
+	both := []string{"br", "gzip"}
+
+	tests := []struct {
+		header    string
+		encodings []string
+		want      string
+	}{
+		{"", both, ""},
+		{"gzip, deflate, br", both, "br"},
+		{"gzip", both, "gzip"},
+		{"GZIP", both, "gzip"},
+		{"br;q=0, gzip", both, "gzip"},
+		{"br;q=0.1, gzip;q=0.9", both, "br"}, // the preference of the manifest wins
+		{"br;q=x, gzip", both, "gzip"},
+		{"deflate, identity", both, ""},
+		{"gzip, br", []string{"gzip"}, "gzip"},
+		{"br", nil, ""},
+		{" br ; q=1 ", both, "br"},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header, tt.encodings); got != tt.want {
+			t.Errorf("negotiateEncoding(%q, %v) = %q, want %q", tt.header, tt.encodings, got, tt.want)
+		}
+	}
+}
+
+// TestTemplatesAssets verifica che le pagine incluse nel binario trovino i propri asset nel manifest.
+func TestTemplatesAssets(t *testing.T) {
+
+	views, err := fs.Sub(viewsFS, "views")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTemplateRegistry(views); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,42 +1,111 @@
 package http
 
 import (
-	_ "embed"
-	"io"
-
+	"bytes"
+	"embed"
+	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"text/template/parse"
+	"time"
 
 	"prova/app"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
-var (
+//go:embed views
+var viewsFS embed.FS
 
-	//go:embed views/layout/base.html
-	BaseTemplateHtml string
-	//go:embed views/layout/head.html
-	HeadTemplateHtml string
+// Pagine del sito, il nome è il percorso del file in views senza estensione.
+const (
+	IndexPage           = "index"
+	ListaPage           = "lista"
+	AdminLoginPage      = "admin/login"
+	AdminUsersPage      = "admin/users"
+	AdminUserPage       = "admin/user"
+	AdminDeleteUserPage = "admin/user_delete"
+)
 
-	// errors template
+// pageSamples contiene per ogni pagina un esempio dei dati con cui è eseguita. All'avvio ogni pagina
+// è eseguita con il suo esempio, così un campo mancante è un errore di avvio e non della richiesta.
+var pageSamples = map[string]any{
+	IndexPage: nil,
+	ListaPage: map[string]any{"Users": []*app.User{{}}},
+	AdminLoginPage: adminPageData[adminLoginForm]{
+		Flash: &flash{},
+		Page:  adminLoginForm{Error: "error"},
+	},
+	AdminUsersPage: adminPageData[adminUserList]{
+		Admin: &app.Admin{},
+		Page:  adminUserList{PaginateResponse: NewPaginateResponse([]*app.User{{}}, 100, 1, adminUsersPerPage)},
+	},
+	AdminUserPage: adminPageData[adminUserForm]{
+		Admin: &app.Admin{},
+		Page:  adminUserForm{User: &app.User{}, Error: "error"},
+	},
+	AdminDeleteUserPage: adminPageData[*app.User]{
+		Admin: &app.Admin{},
+		Page:  &app.User{},
+	},
+}
 
-	// components template
+func init() {
+	for _, name := range errorPages {
+		pageSamples[name] = errorPageData{StatusCode: http.StatusNotFound, Message: "error.not_found", Detail: "detail", RequestID: "id"}
+	}
+}
 
-	// pages templates
+// templateSharedDirs sono le cartelle di views con i template condivisi da tutte le pagine:
+// i layout, i partial con parti di pagina e i componenti riusabili.
+var templateSharedDirs = []string{"layout", "partials", "components"}
 
-	//go:embed views/index.html
-	IndexPageTemplateHtml string
-	IndexPageTemplate     = template.Must(template.New("index").Parse(BaseTemplateHtml + HeadTemplateHtml + IndexPageTemplateHtml))
+// baseLayout è il template da cui inizia il render di ogni pagina.
+const baseLayout = "base.html"
 
-	//go:embed views/lista.html
-	ListaPageTemplateHTML string
-	ListaPageTemplate     = template.Must(template.New("lista").Parse(BaseTemplateHtml + HeadTemplateHtml + ListaPageTemplateHTML))
-)
+// dateLayout è il formato di default della funzione date.
+const dateLayout = "02/01/2006 15:04"
+
+// csrfFormField è il campo dei form con il token CSRF.
+const csrfFormField = "_csrf"
 
 const (
-	ErrLoadingPage = "Errore durante il caricamento della pagina"
+	ErrLoadingPage = "Error loading the page"
 )
 
+// templateFuncs sono le funzioni disponibili nei template. Quelle legate alla richiesta sono qui
+// segnaposto e vengono sostituite ad ogni render da requestFuncs.
+var templateFuncs = template.FuncMap{
+	"date":      formatDate,
+	"plural":    plural,
+	"url":       buildURL,
+	"asset":     assetURL,
+	"integrity": assetIntegrity,
+	"locales":   app.Locales,
+	"t": func(key string, args ...any) string {
+		return app.Translate(app.DefaultLocale, key, args...)
+	},
+	"locale": func() string {
+		return app.DefaultLocale
+	},
+	"localeURL": func(locale string) string {
+		return "/" + locale
+	},
+	"csrfField": func() template.HTML {
+		return csrfField("")
+	},
+	"nonce": func() string {
+		return ""
+	},
+}
+
 // HeadData definisce i dati dell'head della pagina.
 type HeadData struct {
 	Title   string
@@ -50,15 +119,420 @@ type PageTemplateData[T any] struct {
 	ContentData T
 }
 
-// renderPage si occupa di effettuare il render della pagina passata.
-func renderPage[T any](wr io.Writer, t *template.Template, data PageTemplateData[T]) error {
+// TemplateRegistry contiene le pagine già analizzate, ognuna con i template condivisi.
+type TemplateRegistry struct {
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+
+	// dev ricarica le pagine da fsys quando i file cambiano, stamp identifica i file caricati
+	// ed err è l'errore del loro caricamento.
+	dev   bool
+	fsys  fs.FS
+	stamp string
+	err   error
+}
+
+// NewTemplateRegistry carica i template da fsys e verifica ogni pagina con i dati di pageSamples,
+// restituisce un errore se un template, un messaggio o un campo dei dati non esistono.
+func NewTemplateRegistry(fsys fs.FS) (*TemplateRegistry, error) {
+
+	pages, err := loadPages(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TemplateRegistry{pages: pages, fsys: fsys}, nil
+}
+
+// NewDevTemplateRegistry restituisce un registry che ricarica le pagine da fsys quando cambiano, per
+// lo sviluppo. Gli errori dei template non impediscono l'avvio ma sono restituiti da Render.
+func NewDevTemplateRegistry(fsys fs.FS) *TemplateRegistry {
+	r := &TemplateRegistry{dev: true, fsys: fsys}
+	r.reload()
+	return r
+}
+
+// reload carica di nuovo le pagine se i file sono cambiati dall'ultimo caricamento.
+func (r *TemplateRegistry) reload() error {
+
+	stamp, err := templatesStamp(r.fsys)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.err = err
+		return err
+	} else if stamp == r.stamp {
+		return r.err
+	}
+
+	pages, err := loadPages(r.fsys)
+	if err == nil {
+		r.pages = pages
+	}
+	r.stamp, r.err = stamp, err
+
+	return err
+}
+
+// templatesStamp restituisce un'impronta di nomi, dimensioni e date di modifica dei file di fsys.
+func templatesStamp(fsys fs.FS) (string, error) {
+
+	var b strings.Builder
+
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", p, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+
+	return b.String(), err
+}
+
+// loadPages analizza le pagine di fsys e le verifica.
+func loadPages(fsys fs.FS) (map[string]*template.Template, error) {
+
+	shared := template.New("").Option("missingkey=error").Funcs(templateFuncs)
+
+	for _, dir := range templateSharedDirs {
+		files, err := fs.Glob(fsys, dir+"/*.html")
+		if err != nil {
+			return nil, err
+		} else if len(files) == 0 {
+			continue
+		}
+		if _, err := shared.ParseFS(fsys, files...); err != nil {
+			return nil, fmt.Errorf("parsing %s templates: %w", dir, err)
+		}
+	}
+
+	pages := map[string]*template.Template{}
+
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() || path.Ext(p) != ".html" || isSharedTemplate(p) {
+			return nil
+		}
+
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+
+		t, err := shared.Clone()
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(p, ".html")
+		if _, err := t.New(name).Parse(string(b)); err != nil {
+			return fmt.Errorf("parsing page %s: %w", name, err)
+		}
+
+		pages[name] = t
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPages(pages); err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+// isSharedTemplate indica se il file p è in una delle cartelle condivise.
+func isSharedTemplate(p string) bool {
+	for _, dir := range templateSharedDirs {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPages verifica che ogni pagina abbia i dati d'esempio, richiami solo template e messaggi esistenti
+// e sia eseguibile con i suoi dati d'esempio.
+func checkPages(pages map[string]*template.Template) error {
+
+	for name := range pageSamples {
+		if _, ok := pages[name]; !ok {
+			return fmt.Errorf("page %s not found", name)
+		}
+	}
+
+	for name, page := range pages {
+
+		sample, ok := pageSamples[name]
+		if !ok {
+			return fmt.Errorf("page %s has no sample data", name)
+		}
+
+		for _, t := range page.Templates() {
+			if t.Tree == nil {
+				continue
+			}
+			if err := (templateChecker{page: page, tree: t.Tree}).checkNode(t.Tree.Root); err != nil {
+				return fmt.Errorf("page %s: %w", name, err)
+			}
+		}
+
+		data := PageTemplateData[any]{HeadData: HeadData{Title: "sample", AppName: app.AppName, NoIndex: true}, ContentData: sample}
+		if err := executePage(io.Discard, page, data, nil); err != nil {
+			return fmt.Errorf("page %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// templateChecker verifica staticamente i template di una pagina.
+type templateChecker struct {
+	page *template.Template
+	tree *parse.Tree
+}
+
+// errorf restituisce un errore con la posizione di node, nello stesso formato degli errori dei template.
+func (c templateChecker) errorf(node parse.Node, format string, args ...any) error {
+	location, _ := c.tree.ErrorContext(node)
+	return fmt.Errorf("template: %s: %s", location, fmt.Sprintf(format, args...))
+}
+
+// checkNode verifica che i template richiamati da node esistano nella pagina, così come i messaggi
+// passati come costante alla funzione t e gli asset passati ad asset e integrity.
+func (c templateChecker) checkNode(node parse.Node) error {
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := c.checkNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return c.checkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return c.checkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return c.checkBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		if c.page.Lookup(n.Name) == nil {
+			return c.errorf(n, "template %q not defined", n.Name)
+		}
+		return c.checkPipe(n.Pipe)
+	case *parse.ActionNode:
+		return c.checkPipe(n.Pipe)
+	}
+
+	return nil
+}
+
+func (c templateChecker) checkBranch(n *parse.BranchNode) error {
+	if err := c.checkPipe(n.Pipe); err != nil {
+		return err
+	} else if err := c.checkNode(n.List); err != nil {
+		return err
+	}
+	return c.checkNode(n.ElseList)
+}
+
+func (c templateChecker) checkPipe(n *parse.PipeNode) error {
 
-	data.HeadData.AppName = app.AppName
+	if n == nil {
+		return nil
+	}
 
-	return t.Execute(wr, data)
+	for _, cmd := range n.Cmds {
+		for i, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.IdentifierNode:
+				if i+1 >= len(cmd.Args) {
+					continue
+				}
+				key, ok := cmd.Args[i+1].(*parse.StringNode)
+				if !ok {
+					continue
+				}
+				switch a.Ident {
+				case "t":
+					if !app.HasMessage(key.Text) {
+						return c.errorf(key, "message %q not found", key.Text)
+					}
+				case "asset", "integrity":
+					if _, ok := assets[key.Text]; !ok {
+						return c.errorf(key, "asset %q not found", key.Text)
+					}
+				}
+			case *parse.PipeNode:
+				if err := c.checkPipe(a); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Render esegue la pagina name con data, funcs sostituisce le funzioni legate alla richiesta.
+func (r *TemplateRegistry) Render(w io.Writer, name string, data any, funcs template.FuncMap) error {
+
+	if r.dev {
+		if err := r.reload(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.RLock()
+	page, ok := r.pages[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return app.Errorf(app.EINTERNAL, "Page %s not found", name)
+	}
+
+	return executePage(w, page, data, funcs)
 }
 
-// errorPage restituisce una pagina d'errore.
-func errorPage(c echo.Context, httpCode int, msg string) error {
-	return c.HTML(httpCode, msg)
+// executePage esegue la pagina dal layout di base.
+func executePage(w io.Writer, page *template.Template, data any, funcs template.FuncMap) error {
+
+	// a template can't be cloned once executed, so each render executes a new clone.
+	t, err := page.Clone()
+	if err != nil {
+		return err
+	}
+
+	return t.Funcs(funcs).ExecuteTemplate(w, baseLayout, data)
+}
+
+// renderPage effettua il render della pagina name e la restituisce con il codice httpCode.
+func (s *ServerAPI) renderPage(c echo.Context, httpCode int, name string, head HeadData, content any) error {
+
+	head.AppName = app.AppName
+
+	var buf bytes.Buffer
+
+	if err := s.templates.Render(&buf, name, PageTemplateData[any]{HeadData: head, ContentData: content}, s.requestFuncs(c)); err != nil {
+		app.LogErr(s.LogService, err)
+		if s.templates.dev {
+			return templateErrorPage(c, s.templates.fsys, err)
+		}
+		// the error page is rendered with the same templates, so it can't be used here.
+		return c.HTML(http.StatusInternalServerError, template.HTMLEscapeString(app.Translate(requestLocale(c), ErrLoadingPage)))
+	}
+
+	return c.HTML(httpCode, buf.String())
+}
+
+// requestFuncs restituisce le funzioni dei template legate alla richiesta.
+func (s *ServerAPI) requestFuncs(c echo.Context) template.FuncMap {
+
+	csrf, _ := c.Get(middleware.DefaultCSRFConfig.ContextKey).(string)
+	locale := requestLocale(c)
+	nonce := requestNonce(c)
+
+	return template.FuncMap{
+		"t": func(key string, args ...any) string {
+			return app.Translate(locale, key, args...)
+		},
+		"locale": func() string {
+			return locale
+		},
+		"localeURL": func(l string) string {
+			return localeURL(c, l)
+		},
+		"csrfField": func() template.HTML {
+			return csrfField(csrf)
+		},
+		"nonce": func() string {
+			return nonce
+		},
+	}
+}
+
+// csrfField restituisce il campo nascosto con il token CSRF da inserire nei form.
+func csrfField(token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfFormField + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
+
+// formatDate formatta una data con layout o con dateLayout, una data nil è vuota.
+func formatDate(v any, layout ...string) (string, error) {
+
+	l := dateLayout
+	if len(layout) > 0 {
+		l = layout[0]
+	}
+
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(l), nil
+	case *time.Time:
+		if t == nil {
+			return "", nil
+		}
+		return t.Format(l), nil
+	}
+
+	return "", fmt.Errorf("date: unsupported type %T", v)
+}
+
+// plural restituisce one se n è 1, altrimenti other.
+func plural(n int, one, other string) string {
+	if n == 1 {
+		return one
+	}
+	return other
+}
+
+// buildURL costruisce un URL da route e da coppie chiave valore. Le chiavi che corrispondono a un
+// parametro :chiave di route lo sostituiscono, le altre sono aggiunte alla query se non sono vuote.
+func buildURL(route string, pairs ...any) (string, error) {
+
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("url: odd number of key value pairs")
+	}
+
+	segments := strings.Split(route, "/")
+	query := url.Values{}
+
+	for i := 0; i < len(pairs); i += 2 {
+
+		key, ok := pairs[i].(string)
+		if !ok {
+			return "", fmt.Errorf("url: key %v is not a string", pairs[i])
+		}
+		value := fmt.Sprint(pairs[i+1])
+
+		found := false
+		for j, seg := range segments {
+			if seg == ":"+key {
+				segments[j], found = url.PathEscape(value), true
+			}
+		}
+
+		if !found && value != "" {
+			query.Add(key, value)
+		}
+	}
+
+	u := strings.Join(segments, "/")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	return u, nil
 }
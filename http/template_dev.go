@@ -0,0 +1,130 @@
+package http
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// templateErrorRegexp trova il nome e la riga del template negli errori di text/template e html/template.
+var templateErrorRegexp = regexp.MustCompile(`template: ?([^:\s]+):(\d+)`)
+
+// templateErrorLines è il numero di righe mostrate prima e dopo quella dell'errore.
+const templateErrorLines = 5
+
+// templateErrorData sono i dati della pagina d'errore dei template mostrata in sviluppo.
+type templateErrorData struct {
+	Error  string
+	Nonce  string
+	File   string
+	Line   int
+	Source []templateSourceLine
+}
+
+// templateSourceLine è una riga del template mostrata nella pagina d'errore.
+type templateSourceLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+// templateErrorPage restituisce la pagina d'errore dei template usata in sviluppo, con l'errore e le righe
+// del file in cui si è verificato. La pagina non usa il registry, che potrebbe non essere caricato.
+func templateErrorPage(c echo.Context, fsys fs.FS, err error) error {
+
+	data := templateErrorData{Error: err.Error(), Nonce: requestNonce(c)}
+
+	if m := templateErrorRegexp.FindStringSubmatch(data.Error); m != nil {
+		data.File = templateFile(fsys, m[1])
+		data.Line, _ = strconv.Atoi(m[2])
+		data.Source = templateSource(fsys, data.File, data.Line)
+	}
+
+	var buf bytes.Buffer
+
+	if err := templateErrorTemplate.Execute(&buf, data); err != nil {
+		return c.String(http.StatusInternalServerError, data.Error)
+	}
+
+	return c.HTML(http.StatusInternalServerError, buf.String())
+}
+
+// templateFile restituisce il file del template name, i template condivisi hanno il nome del file
+// mentre le pagine il percorso senza estensione.
+func templateFile(fsys fs.FS, name string) string {
+
+	if path.Ext(name) != ".html" {
+		return name + ".html"
+	}
+
+	for _, dir := range templateSharedDirs {
+		if _, err := fs.Stat(fsys, path.Join(dir, name)); err == nil {
+			return path.Join(dir, name)
+		}
+	}
+
+	return name
+}
+
+// templateSource restituisce le righe del file intorno a line.
+func templateSource(fsys fs.FS, file string, line int) []templateSourceLine {
+
+	b, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil
+	}
+
+	var source []templateSourceLine
+
+	for i, text := range strings.Split(string(b), "\n") {
+		if n := i + 1; n >= line-templateErrorLines && n <= line+templateErrorLines {
+			source = append(source, templateSourceLine{Number: n, Text: text, Current: n == line})
+		}
+	}
+
+	return source
+}
+
+var templateErrorTemplate = template.Must(template.New("template_error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>Template error</title>
+    <style nonce="{{.Nonce}}">
+        body { margin: 0; font-family: system-ui, sans-serif; background: #f8f9fa; color: #212529; }
+        header { padding: 24px 32px; background: #dc3545; color: #fff; }
+        header h1 { margin: 0 0 8px; font-size: 20px; }
+        header p { margin: 0; font-family: ui-monospace, monospace; white-space: pre-wrap; }
+        main { padding: 24px 32px; }
+        h2 { font-size: 16px; font-family: ui-monospace, monospace; }
+        pre { margin: 0; background: #fff; border: 1px solid #dee2e6; border-radius: 4px; overflow-x: auto; }
+        .line { display: block; padding: 0 12px; }
+        .line.current { background: #f8d7da; }
+        .number { display: inline-block; width: 40px; color: #6c757d; user-select: none; }
+        footer { padding: 0 32px; color: #6c757d; font-size: 14px; }
+    </style>
+</head>
+<body>
+    <header>
+        <h1>Template error</h1>
+        <p>{{.Error}}</p>
+    </header>
+    {{if .Source}}
+    <main>
+        <h2>{{.File}}:{{.Line}}</h2>
+        <pre>{{range .Source}}<span class="line{{if .Current}} current{{end}}"><span class="number">{{.Number}}</span>{{.Text}}</span>{{end}}</pre>
+    </main>
+    {{end}}
+    <footer>
+        <p>Templates are reloaded from disk when they change, fix the error and refresh the page.</p>
+    </footer>
+</body>
+</html>
+`))
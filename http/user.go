@@ -1,20 +1,300 @@
 package http
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 
 	"prova/app"
+
 	"github.com/labstack/echo/v4"
 )
 
 func (s *ServerAPI) handlerIndexPage(c echo.Context) error {
 
-	var buf bytes.Buffer
+	return s.renderPage(c, http.StatusOK, IndexPage, HeadData{}, nil)
+}
+
+// registerUserRoutes registra le rotte API per la gestione degli user.
+func (s *ServerAPI) registerUserRoutes(g *echo.Group) {
+	g.GET("/users", s.handlerFindUsers)
+	g.GET("/users/:id", s.handlerFindUserByID)
+	g.PATCH("/users/:id", s.handlerUpdateUser)
+	g.POST("/users/import", s.handlerImportUsers)
+	g.GET("/users/export", s.handlerExportUsers)
+}
+
+func (s *ServerAPI) handlerFindUsers(c echo.Context) error {
+
+	filter, err := parseUserFilter(c)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	users, n, err := s.UserService.FindUsers(c.Request().Context(), filter)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	for _, user := range users {
+		s.presentUser(c, user)
+	}
+
+	// with pagination=cursor the first page is requested without after & before.
+	if c.QueryParam("pagination") == "cursor" || filter.After != nil || filter.Before != nil {
+		return SuccessResponseJSON(c, http.StatusOK, NewCursorResponse(users, n, filter.SkipCount, filter.Limit, filter.After, filter.Before, func(u *app.User) string {
+			return u.Cursor(filter.SortBy, filter.SortDir).Encode()
+		}))
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, NewPaginateResponse(users, n, filter.Page, filter.Limit))
+}
+
+// parseUserFilter legge il filtro degli user dai parametri della query.
+func parseUserFilter(c echo.Context) (app.UserFilter, error) {
+
+	var filter app.UserFilter
+
+	if v := c.QueryParam("email"); v != "" {
+		filter.Email = &v
+	}
+
+	if v := c.QueryParam("q"); v != "" {
+		filter.Search = &v
+	}
+
+	if v := c.QueryParam("phone"); v != "" {
+		filter.PhonePrefix = &v
+	}
+
+	if v := c.QueryParam("after"); v != "" {
+		filter.After = &v
+	}
+
+	if v := c.QueryParam("before"); v != "" {
+		filter.Before = &v
+	}
+
+	var createdAfter, createdBefore time.Time
+
+	if err := echo.QueryParamsBinder(c).
+		Int64s("ids", &filter.IDs).
+		Bool("skip_count", &filter.SkipCount).
+		Bool("deleted", &filter.Deleted).
+		Bool("include_deleted", &filter.IncludeDeleted).
+		Time("created_after", &createdAfter, time.RFC3339).
+		Time("created_before", &createdBefore, time.RFC3339).
+		String("sort_by", &filter.SortBy).
+		String("sort_dir", &filter.SortDir).
+		Int("page", &filter.Page).
+		Int("limit", &filter.Limit).
+		BindError(); err != nil {
+		return app.UserFilter{}, err
+	}
+
+	if c.QueryParam("created_after") != "" {
+		filter.CreatedAfter = &createdAfter
+	}
+
+	if c.QueryParam("created_before") != "" {
+		filter.CreatedBefore = &createdBefore
+	}
+
+	return filter, nil
+}
+
+func (s *ServerAPI) handlerSearchUsers(c echo.Context) error {
+
+	limit := 10
+
+	if err := echo.QueryParamsBinder(c).
+		Int("limit", &limit).
+		BindError(); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	users, err := s.UserService.SearchUsers(c.Request().Context(), c.QueryParam("q"), limit)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	for _, user := range users {
+		s.presentUser(c, user)
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, users)
+}
+
+// handlerImportUsers importa gli user dal corpo della richiesta, letto man mano senza caricarlo in memoria.
+// Il formato è indicato dal parametro format oppure dal Content-Type, se l'import è annullato risponde 422.
+func (s *ServerAPI) handlerImportUsers(c echo.Context) error {
+
+	var opts app.UserImportOptions
+
+	if err := echo.QueryParamsBinder(c).
+		Bool("all_or_nothing", &opts.AllOrNothing).
+		Int("batch_size", &opts.BatchSize).
+		BindError(); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		switch mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType)); mediaType {
+		case "text/csv":
+			format = app.UserImportFormatCSV
+		case "application/jsonl", "application/x-ndjson":
+			format = app.UserImportFormatJSONL
+		}
+	}
+
+	src, err := app.NewUserImportSource(c.Request().Body, format)
+	if err != nil {
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	report, err := s.UserService.ImportUsers(c.Request().Context(), src, opts)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	if !report.Committed {
+		return SuccessResponseJSON(c, http.StatusUnprocessableEntity, report)
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, report)
+}
+
+// userExportHeader sono le colonne dell'export degli user, la password non è mai esportata.
+var userExportHeader = []string{"id", "name", "surname", "email", "phone", "created_at", "updated_at", "deleted_at"}
+
+// handlerExportUsers esporta in CSV o XLSX tutti gli user che corrispondono ai filtri della lista, senza paginazione.
+// Il file è scritto mentre le righe sono lette dal database, senza tenere il risultato in memoria.
+func (s *ServerAPI) handlerExportUsers(c echo.Context) error {
+
+	filter, err := parseUserFilter(c)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	w, mimeType, ext, err := newTableWriter(pw, c.QueryParam("format"), userExportHeader)
+	if err != nil {
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	go func() {
+		err := s.UserService.ExportUsers(c.Request().Context(), filter, func(u *app.User) error {
+			return w.Write([]any{u.ID, u.Name, u.Surname, u.Email, u.Phone, u.CreatedAt, u.UpdatedAt, u.DeletedAt})
+		})
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	// nothing is written before the first row or the end of the query, so an invalid filter
+	// is still reported as an error response.
+	r := bufio.NewReader(pr)
+	if _, err := r.Peek(1); err != nil && !errors.Is(err, io.EOF) {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	maxAge := int64(0)
+	if err := DownloadFile(c, r, DownloadFileConfig{
+		Filename:   fmt.Sprintf("users-%s.%s", time.Now().UTC().Format("20060102-150405"), ext),
+		MimeType:   mimeType,
+		MaxAge:     &maxAge,
+		Attachment: true,
+	}); err != nil {
+		app.LogErr(s.LogService, err)
+	}
+
+	return nil
+}
+
+func (s *ServerAPI) handlerFindUserByID(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	user, err := s.UserService.FindUserByID(c.Request().Context(), id)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	c.Response().Header().Set("ETag", entityETag(user.ID, user.Version))
+	s.presentUser(c, user)
+
+	return SuccessResponseJSON(c, http.StatusOK, user)
+}
+
+func (s *ServerAPI) handlerUpdateUser(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
 
-	if err := renderPage(&buf, IndexPageTemplate, PageTemplateData[map[string]any]{}); err != nil {
+	var upd app.UserUpdate
+
+	if err := c.Bind(&upd); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	// with If-Match the expected version is the current one, a concurrent update between
+	// the read and the write makes the service fail with ECONFLICT.
+	if c.Request().Header.Get("If-Match") != "" {
+
+		user, err := s.UserService.FindUserByID(c.Request().Context(), id)
+		if err != nil {
+			app.LogErr(s.LogService, err)
+			return ErrorResponseJSON(c, err, nil)
+		}
+
+		if !matchesIfMatch(c, entityETag(user.ID, user.Version)) {
+			return PreconditionFailedErrorJSON(c)
+		}
+
+		upd.Version = &user.Version
+	}
+
+	user, err := s.UserService.UpdateUser(c.Request().Context(), id, upd)
+	if err != nil {
+		// the precondition has failed only if the user has changed since the check, otherwise the conflict is another one.
+		if app.ErrorCode(err) == app.ECONFLICT && upd.Version != nil {
+			if current, ferr := s.UserService.FindUserByID(c.Request().Context(), id); ferr == nil && current.Version != *upd.Version {
+				return PreconditionFailedErrorJSON(c)
+			}
+		}
 		app.LogErr(s.LogService, err)
-		return errorPage(c, http.StatusInternalServerError, ErrLoadingPage)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	c.Response().Header().Set("ETag", entityETag(user.ID, user.Version))
+	s.presentUser(c, user)
+
+	return SuccessResponseJSON(c, http.StatusOK, user)
+}
+
+// presentUser prepara uno user per la risposta, rimuove la password e imposta gli URL dell'avatar.
+func (s *ServerAPI) presentUser(c echo.Context, user *app.User) {
+	user.Password = ""
+	if user.Avatar != nil {
+		s.setFileURLs(c, user.Avatar)
 	}
-	return c.HTML(http.StatusOK, buf.String())
 }
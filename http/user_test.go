@@ -0,0 +1,78 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"prova/app"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/labstack/echo/v4"
+)
+
+// updateUserStub simula un aggiornamento che fallisce con err, reads sono gli user restituiti dalle letture in ordine.
+type updateUserStub struct {
+	app.UserService
+	reads []*app.User
+	err   error
+}
+
+func (s *updateUserStub) FindUserByID(ctx context.Context, id int64) (*app.User, error) {
+	user := s.reads[0]
+	s.reads = s.reads[1:]
+	return user, nil
+}
+
+func (s *updateUserStub) UpdateUser(ctx context.Context, id int64, upd app.UserUpdate) (*app.User, error) {
+	return nil, s.err
+}
+
+func TestHandlerUpdateUserConflict(t *testing.T) {
+
+	v1, v2 := &app.User{ID: 1, Version: 1}, &app.User{ID: 1, Version: 2}
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		reads   []*app.User
+		err     error
+		want    int
+	}{
+		{"stale etag", `"1-0"`, []*app.User{v1}, nil, http.StatusPreconditionFailed},
+		{"concurrent update", `"1-1"`, []*app.User{v1, v2}, app.Errorf(app.ECONFLICT, "User has been modified by someone else"), http.StatusPreconditionFailed},
+		{"email in use", `"1-1"`, []*app.User{v1, v1}, app.Errorf(app.ECONFLICT, "Email already in use"), http.StatusConflict},
+		{"email in use without if-match", "", nil, app.Errorf(app.ECONFLICT, "Email already in use"), http.StatusConflict},
+	}
+
+	e := echo.New()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			s := NewServerAPI()
+			s.UserService = &updateUserStub{reads: tt.reads, err: tt.err}
+			s.LogService = log.New()
+			s.LogService.SetHandler(log.DiscardHandler())
+
+			req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"email":"mario@example.com"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+
+			if err := s.handlerUpdateUser(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
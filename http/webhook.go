@@ -0,0 +1,237 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"prova/app"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Header inviati con ogni consegna webhook.
+const (
+	HeaderWebhookEvent     = "X-Webhook-Event"
+	HeaderWebhookDelivery  = "X-Webhook-Delivery"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+)
+
+var _ app.WebhookSender = (*WebhookClient)(nil)
+
+// WebhookClient invia le consegne webhook tramite HTTP POST, firmando il payload e l'ora dell'invio con HMAC-SHA256.
+type WebhookClient struct {
+	client *http.Client
+}
+
+// NewWebhookClient returns a new WebhookClient with the given request timeout.
+func NewWebhookClient(timeout time.Duration) *WebhookClient {
+	return &WebhookClient{
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// SendWebhook implements app.WebhookSender.
+// Any response status outside the 2xx range is reported as an error so the delivery is retried.
+func (c *WebhookClient) SendWebhook(ctx context.Context, w *app.Webhook, d *app.WebhookDelivery) (int, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, app.Errorf(app.EINTERNAL, "Error creating webhook request: %v", err)
+	}
+
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(HeaderWebhookEvent, d.EventType)
+	req.Header.Set(HeaderWebhookDelivery, strconv.FormatInt(d.ID, 10))
+	timestamp := time.Now().Unix()
+	req.Header.Set(HeaderWebhookTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderWebhookSignature, SignWebhookPayload(w.Secret, timestamp, d.Payload))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, app.Errorf(app.EUNAVAILABLE, "Error sending webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// drain the body to reuse the connection.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, app.Errorf(app.EUNAVAILABLE, "Webhook endpoint responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// SignWebhookPayload restituisce il valore dell'header X-Webhook-Signature per il payload inviato
+// all'ora timestamp, in secondi Unix come nell'header X-Webhook-Timestamp. La firma è calcolata su
+// timestamp + "." + payload, così una consegna intercettata non può essere ripetuta più tardi.
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature verifica gli header X-Webhook-Timestamp e X-Webhook-Signature di una consegna
+// ricevuta all'ora now, rifiutando quelle inviate più di tolerance prima o dopo.
+func VerifyWebhookSignature(secret, timestamp, signature string, payload []byte, now time.Time, tolerance time.Duration) bool {
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(SignWebhookPayload(secret, ts, payload)))
+}
+
+// registerWebhookRoutes registra le rotte per la gestione dei webhook.
+func (s *ServerAPI) registerWebhookRoutes(g *echo.Group) {
+	g.GET("/webhooks", s.handlerFindWebhooks)
+	g.POST("/webhooks", s.handlerCreateWebhook)
+	g.GET("/webhooks/:id", s.handlerFindWebhookByID)
+	g.PATCH("/webhooks/:id", s.handlerUpdateWebhook)
+	g.DELETE("/webhooks/:id", s.handlerDeleteWebhook)
+	g.GET("/webhooks/:id/deliveries", s.handlerFindWebhookDeliveries)
+}
+
+func (s *ServerAPI) handlerFindWebhooks(c echo.Context) error {
+
+	var filter app.WebhookFilter
+
+	if err := echo.QueryParamsBinder(c).
+		Int("page", &filter.Page).
+		Int("limit", &filter.Limit).
+		BindError(); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	webhooks, n, err := s.WebhookService.FindWebhooks(c.Request().Context(), filter)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, NewPaginateResponse(webhooks, n, filter.Page, filter.Limit))
+}
+
+// webhookCreateResponse è la risposta alla creazione di un webhook, l'unica che contiene il secret.
+type webhookCreateResponse struct {
+	*app.Webhook
+	Secret string `json:"secret"`
+}
+
+func (s *ServerAPI) handlerCreateWebhook(c echo.Context) error {
+
+	var crt app.WebhookCreate
+
+	if err := c.Bind(&crt); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	webhook, err := s.WebhookService.CreateWebhook(c.Request().Context(), crt)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	return SuccessResponseJSON(c, http.StatusCreated, webhookCreateResponse{Webhook: webhook, Secret: webhook.Secret})
+}
+
+func (s *ServerAPI) handlerFindWebhookByID(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	webhook, err := s.WebhookService.FindWebhookByID(c.Request().Context(), id)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, webhook)
+}
+
+func (s *ServerAPI) handlerUpdateWebhook(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	var upd app.WebhookUpdate
+
+	if err := c.Bind(&upd); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	webhook, err := s.WebhookService.UpdateWebhook(c.Request().Context(), id, upd)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, webhook)
+}
+
+func (s *ServerAPI) handlerDeleteWebhook(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	if err := s.WebhookService.DeleteWebhook(c.Request().Context(), id); err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	return SuccessResponseJSON(c, http.StatusNoContent, nil)
+}
+
+func (s *ServerAPI) handlerFindWebhookDeliveries(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	filter := app.WebhookDeliveryFilter{WebhookID: &id}
+
+	if v := c.QueryParam("status"); v != "" {
+		filter.Status = &v
+	}
+
+	if err := echo.QueryParamsBinder(c).
+		Int("page", &filter.Page).
+		Int("limit", &filter.Limit).
+		BindError(); err != nil {
+		return InvalidRequestErrorJSON(c)
+	}
+
+	if _, err := s.WebhookService.FindWebhookByID(c.Request().Context(), id); err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	deliveries, n, err := s.WebhookService.FindWebhookDeliveries(c.Request().Context(), filter)
+	if err != nil {
+		app.LogErr(s.LogService, err)
+		return ErrorResponseJSON(c, err, nil)
+	}
+
+	return SuccessResponseJSON(c, http.StatusOK, NewPaginateResponse(deliveries, n, filter.Page, filter.Limit))
+}
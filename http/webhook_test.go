@@ -0,0 +1,74 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"prova/app"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+
+	now := time.Unix(1700000000, 0)
+	payload := []byte(`{"id":1}`)
+	signature := SignWebhookPayload("secret", now.Unix(), payload)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		payload   []byte
+		now       time.Time
+		want      bool
+	}{
+		{"valid", "secret", "1700000000", signature, payload, now, true},
+		{"within tolerance", "secret", "1700000000", signature, payload, now.Add(5 * time.Minute), true},
+		{"clock skew", "secret", "1700000000", signature, payload, now.Add(-5 * time.Minute), true},
+		{"stale", "secret", "1700000000", signature, payload, now.Add(5*time.Minute + time.Second), false},
+		{"timestamp changed", "secret", "1700000001", signature, payload, now, false},
+		{"payload changed", "secret", "1700000000", signature, []byte(`{"id":2}`), now, false},
+		{"wrong secret", "other", "1700000000", signature, payload, now, false},
+		{"invalid timestamp", "secret", "now", signature, payload, now, false},
+		{"missing signature", "secret", "1700000000", "", payload, now, false},
+	}
+
+	for _, tt := range tests {
+		if got := VerifyWebhookSignature(tt.secret, tt.timestamp, tt.signature, tt.payload, tt.now, 5*time.Minute); got != tt.want {
+			t.Errorf("%s: VerifyWebhookSignature() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookClientSignsTimestamp(t *testing.T) {
+
+	var req *http.Request
+	var body []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	w := &app.Webhook{URL: ts.URL, Secret: "secret"}
+	d := &app.WebhookDelivery{ID: 7, EventType: "user.created", Payload: []byte(`{"id":1}`)}
+
+	if _, err := NewWebhookClient(time.Second).SendWebhook(context.Background(), w, d); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := req.Header.Get(HeaderWebhookTimestamp)
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		t.Fatalf("invalid %s header %q", HeaderWebhookTimestamp, timestamp)
+	}
+
+	if !VerifyWebhookSignature("secret", timestamp, req.Header.Get(HeaderWebhookSignature), body, time.Now(), time.Minute) {
+		t.Errorf("signature %q doesn't match the body and timestamp", req.Header.Get(HeaderWebhookSignature))
+	}
+}
@@ -0,0 +1,117 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// exifOrientationTag è il tag EXIF dell'orientamento dell'immagine.
+const exifOrientationTag = 0x0112
+
+// Orientation restituisce l'orientamento EXIF di un JPEG o di un WebP, da 1 a 8, oppure 1 se non è
+// indicato. Solo l'IFD0 dei metadati EXIF viene letto, gli altri metadati sono ignorati.
+func Orientation(b []byte) int {
+
+	if len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP" {
+		return webpOrientation(b[12:])
+	} else if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(b); {
+
+		if b[i] != 0xFF {
+			return 1
+		}
+
+		marker := b[i+1]
+		// the markers without length are skipped, the scan starts the image data.
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			i += 2
+			continue
+		} else if marker == 0xD9 || marker == 0xDA {
+			return 1
+		}
+
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		if n < 2 || i+2+n > len(b) {
+			return 1
+		}
+
+		if segment := b[i+4 : i+2+n]; marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+
+		i += 2 + n
+	}
+
+	return 1
+}
+
+// webpOrientation legge l'orientamento dal chunk EXIF dei chunk RIFF di un WebP.
+func webpOrientation(b []byte) int {
+
+	for len(b) >= 8 {
+
+		n := int(binary.LittleEndian.Uint32(b[4:]))
+		if n < 0 || n > len(b)-8 {
+			return 1
+		}
+
+		if chunk := b[8 : 8+n]; string(b[:4]) == "EXIF" {
+			// some encoders keep the JPEG prefix of the EXIF data.
+			return tiffOrientation(bytes.TrimPrefix(chunk, []byte("Exif\x00\x00")))
+		}
+
+		// the chunks are padded to an even size.
+		b = b[min(len(b), 8+n+n%2):]
+	}
+
+	return 1
+}
+
+// tiffOrientation legge l'orientamento dall'IFD0 di un header TIFF.
+func tiffOrientation(t []byte) int {
+
+	if len(t) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	if order.Uint16(t[2:]) != 42 {
+		return 1
+	}
+
+	ifd := int(order.Uint32(t[4:]))
+	if ifd < 8 || ifd+2 > len(t) {
+		return 1
+	}
+
+	entries := int(order.Uint16(t[ifd:]))
+	for i := 0; i < entries; i++ {
+
+		e := ifd + 2 + i*12
+		if e+12 > len(t) {
+			return 1
+		}
+
+		if order.Uint16(t[e:]) == exifOrientationTag {
+			// the value is a SHORT stored in the first bytes of the value field.
+			if o := int(order.Uint16(t[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+
+	return 1
+}
@@ -0,0 +1,141 @@
+// Package imaging normalizza le immagini caricate: applica l'orientamento EXIF, rimuove i metadati
+// e genera le varianti ridimensionate.
+//
+// Le immagini sono decodificate da JPEG, PNG, GIF e WebP, quest'ultimo con golang.org/x/image/webp
+// che non ha un encoder, e codificate in JPEG, oppure in PNG se possono avere trasparenze.
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"prova/app"
+
+	_ "golang.org/x/image/webp"
+)
+
+// MaxPixels è il numero massimo di pixel di un'immagine decodificata, un file piccolo può dichiarare
+// dimensioni enormi ed esaurire la memoria.
+const MaxPixels = 16_000_000
+
+// JPEGQuality è la qualità delle immagini codificate in JPEG.
+const JPEGQuality = 85
+
+// Formati delle immagini codificate.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+// Decode decodifica un'immagine JPEG, PNG, GIF o WebP e la ruota secondo l'orientamento EXIF.
+// Il formato restituito è quello con cui codificarla, JPEG per i JPEG e i WebP lossy senza
+// trasparenza, PNG negli altri casi.
+func Decode(r io.Reader) (*image.RGBA, string, error) {
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", app.Errorf(app.EINTERNAL, "Error reading image: %v", err)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", app.Errorf(app.EINVALID, "Unsupported image: %v", err)
+	} else if cfg.Width*cfg.Height > MaxPixels || cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, "", app.Errorf(app.EINVALID, "Image of %dx%d pixels is too large", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", app.Errorf(app.EINVALID, "Invalid image: %v", err)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	switch format {
+	case "jpeg":
+		return Orient(rgba, Orientation(b)), FormatJPEG, nil
+	case "webp":
+		// the lossy images without alpha are decoded as YCbCr, like the JPEGs.
+		if _, ok := img.(*image.YCbCr); ok {
+			return Orient(rgba, Orientation(b)), FormatJPEG, nil
+		}
+		return Orient(rgba, Orientation(b)), FormatPNG, nil
+	}
+
+	return rgba, FormatPNG, nil
+}
+
+// Encode codifica l'immagine nel formato passato, senza metadati.
+func Encode(w io.Writer, img image.Image, format string) error {
+
+	var err error
+	if format == FormatJPEG {
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+	} else {
+		err = png.Encode(w, img)
+	}
+
+	if err != nil {
+		return app.Errorf(app.EINTERNAL, "Error encoding image: %v", err)
+	}
+
+	return nil
+}
+
+// MimeType restituisce il MIME type di un formato.
+func MimeType(format string) string {
+	if format == FormatJPEG {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Orient applica all'immagine la trasformazione che la porta dritta per l'orientamento EXIF o.
+func Orient(img *image.RGBA, o int) *image.RGBA {
+
+	if o <= 1 || o > 8 {
+		return img
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+
+			var sx, sy int
+			switch o {
+			case 2: // flip horizontal
+				sx, sy = w-1-dx, dy
+			case 3: // rotate 180
+				sx, sy = w-1-dx, h-1-dy
+			case 4: // flip vertical
+				sx, sy = dx, h-1-dy
+			case 5: // transpose
+				sx, sy = dy, dx
+			case 6: // rotate 90 clockwise
+				sx, sy = dy, h-1-dx
+			case 7: // transverse
+				sx, sy = w-1-dy, h-1-dx
+			case 8: // rotate 90 counterclockwise
+				sx, sy = w-1-dy, dx
+			}
+
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], img.Pix[img.PixOffset(sx, sy):img.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,286 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+
+	"prova/app"
+)
+
+// The WebP fixtures in testdata come from golang.org/x/image/testdata.
+
+// exifTIFF restituisce un header TIFF con l'orientamento o nell'IFD0, seguito da extra.
+func exifTIFF(order binary.ByteOrder, o uint16, extra string) []byte {
+
+	b := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(b, "II")
+	} else {
+		copy(b, "MM")
+	}
+	order.PutUint16(b[2:], 42)
+	order.PutUint32(b[4:], 8)
+	order.PutUint16(b[8:], 1)
+	order.PutUint16(b[10:], exifOrientationTag)
+	order.PutUint16(b[12:], 3) // SHORT
+	order.PutUint32(b[14:], 1)
+	order.PutUint16(b[18:], o)
+
+	return append(b, extra...)
+}
+
+// withJPEGEXIF aggiunge al JPEG b un segmento APP1 con i dati EXIF tiff.
+func withJPEGEXIF(b, tiff []byte) []byte {
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(out[4:], uint16(len(segment)+2))
+	out = append(out, segment...)
+
+	return append(out, b[2:]...)
+}
+
+// withWebPEXIF aggiunge al WebP semplice b, di dimensioni w per h, gli header VP8X e il chunk EXIF con i dati data.
+func withWebPEXIF(b []byte, w, h int, data []byte) []byte {
+
+	chunk := func(fourCC string, data []byte) []byte {
+		c := append([]byte(fourCC), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(c[4:], uint32(len(data)))
+		c = append(c, data...)
+		if len(data)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 1 << 3 // EXIF metadata
+	vp8x[4], vp8x[5], vp8x[6] = byte(w-1), byte((w-1)>>8), byte((w-1)>>16)
+	vp8x[7], vp8x[8], vp8x[9] = byte(h-1), byte((h-1)>>8), byte((h-1)>>16)
+
+	body := append([]byte("WEBP"), chunk("VP8X", vp8x)...)
+	body = append(body, b[12:]...)
+	body = append(body, chunk("EXIF", data)...)
+
+	out := append([]byte("RIFF"), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)))
+
+	return append(out, body...)
+}
+
+// halves restituisce un'immagine w per h con la metà sinistra rossa e la destra blu.
+func halves(w, h int) *image.RGBA {
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestOrient(t *testing.T) {
+
+	// every pixel of the 3x2 source has its own color, to follow where it ends up.
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), A: 255})
+		}
+	}
+
+	tests := []struct {
+		o int
+		// w and h are the size of the result, p00 and p10 where the source pixels (0,0) and (1,0) end up.
+		w, h     int
+		p00, p10 image.Point
+	}{
+		{0, 3, 2, image.Pt(0, 0), image.Pt(1, 0)},
+		{1, 3, 2, image.Pt(0, 0), image.Pt(1, 0)},
+		{2, 3, 2, image.Pt(2, 0), image.Pt(1, 0)},
+		{3, 3, 2, image.Pt(2, 1), image.Pt(1, 1)},
+		{4, 3, 2, image.Pt(0, 1), image.Pt(1, 1)},
+		{5, 2, 3, image.Pt(0, 0), image.Pt(0, 1)},
+		{6, 2, 3, image.Pt(1, 0), image.Pt(1, 1)},
+		{7, 2, 3, image.Pt(1, 2), image.Pt(1, 1)},
+		{8, 2, 3, image.Pt(0, 2), image.Pt(0, 1)},
+		{9, 3, 2, image.Pt(0, 0), image.Pt(1, 0)},
+	}
+
+	for _, tt := range tests {
+
+		dst := Orient(src, tt.o)
+
+		if got := dst.Bounds().Size(); got != image.Pt(tt.w, tt.h) {
+			t.Errorf("orientation %d: size = %v, want %dx%d", tt.o, got, tt.w, tt.h)
+			continue
+		}
+		if got := dst.RGBAAt(tt.p00.X, tt.p00.Y); got != src.RGBAAt(0, 0) {
+			t.Errorf("orientation %d: pixel at %v = %v, want the source pixel (0,0)", tt.o, tt.p00, got)
+		}
+		if got := dst.RGBAAt(tt.p10.X, tt.p10.Y); got != src.RGBAAt(1, 0) {
+			t.Errorf("orientation %d: pixel at %v = %v, want the source pixel (1,0)", tt.o, tt.p10, got)
+		}
+	}
+}
+
+func TestOrientation(t *testing.T) {
+
+	plain := encodeJPEG(t, halves(16, 16))
+	lossy := readFixture(t, "blue-purple-pink.lossy.webp")
+
+	tests := []struct {
+		name string
+		b    []byte
+		want int
+	}{
+		{"jpeg without exif", plain, 1},
+		{"jpeg little endian", withJPEGEXIF(plain, exifTIFF(binary.LittleEndian, 6, "")), 6},
+		{"jpeg big endian", withJPEGEXIF(plain, exifTIFF(binary.BigEndian, 8, "")), 8},
+		{"jpeg invalid orientation", withJPEGEXIF(plain, exifTIFF(binary.LittleEndian, 9, "")), 1},
+		{"jpeg truncated exif", withJPEGEXIF(plain, exifTIFF(binary.LittleEndian, 6, "")[:12]), 1},
+		{"png", encodePNG(t, halves(4, 4)), 1},
+		{"webp without exif", lossy, 1},
+		{"webp", withWebPEXIF(lossy, 150, 100, exifTIFF(binary.LittleEndian, 3, "")), 3},
+		{"webp with jpeg prefix", withWebPEXIF(lossy, 150, 100, append([]byte("Exif\x00\x00"), exifTIFF(binary.BigEndian, 5, "")...)), 5},
+		{"webp truncated", withWebPEXIF(lossy, 150, 100, exifTIFF(binary.LittleEndian, 6, ""))[:100], 1},
+		{"empty", nil, 1},
+	}
+
+	for _, tt := range tests {
+		if got := Orientation(tt.b); got != tt.want {
+			t.Errorf("%s: Orientation() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+
+	rotated := withJPEGEXIF(encodeJPEG(t, halves(32, 16)), exifTIFF(binary.LittleEndian, 6, ""))
+	lossy := readFixture(t, "blue-purple-pink.lossy.webp")
+
+	// a PNG header can declare any size, the decoding must stop before allocating it.
+	huge := encodePNG(t, halves(1, 1))
+	binary.BigEndian.PutUint32(huge[16:], 10000)
+	binary.BigEndian.PutUint32(huge[20:], 10000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	tests := []struct {
+		name   string
+		b      []byte
+		format string
+		size   image.Point
+	}{
+		{"jpeg", encodeJPEG(t, halves(32, 16)), FormatJPEG, image.Pt(32, 16)},
+		{"jpeg with orientation", rotated, FormatJPEG, image.Pt(16, 32)},
+		{"png", encodePNG(t, halves(4, 2)), FormatPNG, image.Pt(4, 2)},
+		{"webp lossy", lossy, FormatJPEG, image.Pt(150, 100)},
+		{"webp lossless", readFixture(t, "gopher-doc.1bpp.lossless.webp"), FormatPNG, image.Pt(75, 100)},
+		{"webp with orientation", withWebPEXIF(lossy, 150, 100, exifTIFF(binary.LittleEndian, 6, "")), FormatJPEG, image.Pt(100, 150)},
+		{"not an image", []byte("%PDF-1.4"), "", image.Point{}},
+		{"too large", huge, "", image.Point{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			img, format, err := Decode(bytes.NewReader(tt.b))
+			if tt.format == "" {
+				if app.ErrorCode(err) != app.EINVALID {
+					t.Fatalf("error = %v, want %s", err, app.EINVALID)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if format != tt.format {
+				t.Errorf("format = %q, want %q", format, tt.format)
+			}
+			if got := img.Bounds().Size(); got != tt.size {
+				t.Errorf("size = %v, want %v", got, tt.size)
+			}
+		})
+	}
+
+	// rotated clockwise, the red left half of the source is on top.
+	img, _, err := Decode(bytes.NewReader(rotated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top, bottom := img.RGBAAt(8, 4), img.RGBAAt(8, 28); top.R < 200 || top.B > 50 || bottom.B < 200 || bottom.R > 50 {
+		t.Errorf("rotated pixels = %v on top and %v on bottom, want red and blue", top, bottom)
+	}
+}
+
+func TestEncodeStripsMetadata(t *testing.T) {
+
+	src := withJPEGEXIF(encodeJPEG(t, halves(32, 16)), exifTIFF(binary.BigEndian, 6, "GPS 45.4642N 9.1900E"))
+
+	img, format, err := Decode(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{format, FormatPNG} {
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, format); err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(buf.Bytes(), []byte("Exif")) || bytes.Contains(buf.Bytes(), []byte("GPS")) {
+			t.Errorf("%s: the encoded image contains the source metadata", format)
+		}
+		if o := Orientation(buf.Bytes()); o != 1 {
+			t.Errorf("%s: orientation = %d, want 1", format, o)
+		}
+
+		// the pixels are already rotated, decoding again must not rotate them twice.
+		again, _, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := again.Bounds().Size(); got != image.Pt(16, 32) {
+			t.Errorf("%s: size = %v, want 16x32", format, got)
+		}
+	}
+}
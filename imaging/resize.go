@@ -0,0 +1,113 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// Fit riduce l'immagine perché stia in un quadrato di lato size mantenendo le proporzioni,
+// un'immagine già abbastanza piccola è restituita senza ingrandirla.
+func Fit(img *image.RGBA, size int) *image.RGBA {
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		return Resize(img, size, max(1, int(math.Round(float64(h)*float64(size)/float64(w)))))
+	}
+	return Resize(img, max(1, int(math.Round(float64(w)*float64(size)/float64(h)))), size)
+}
+
+// Resize ridimensiona l'immagine a w x h con un filtro triangolare allargato in proporzione alla riduzione,
+// così ogni pixel del risultato media tutti i pixel che copre senza aliasing.
+func Resize(img *image.RGBA, w, h int) *image.RGBA {
+
+	src := img.Bounds()
+	sw, sh := src.Dx(), src.Dy()
+
+	// the horizontal pass keeps float values to not round twice.
+	tmp := make([]float32, w*sh*4)
+	xw := resampleWeights(sw, w)
+	for y := 0; y < sh; y++ {
+		row := img.Pix[img.PixOffset(src.Min.X, src.Min.Y+y):]
+		for x, ws := range xw {
+			var px [4]float32
+			for _, c := range ws {
+				for k := 0; k < 4; k++ {
+					px[k] += float32(row[c.i*4+k]) * c.w
+				}
+			}
+			copy(tmp[(y*w+x)*4:], px[:])
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	yw := resampleWeights(sh, h)
+	for y, ws := range yw {
+		for x := 0; x < w; x++ {
+			var px [4]float32
+			for _, c := range ws {
+				for k := 0; k < 4; k++ {
+					px[k] += tmp[(c.i*w+x)*4+k] * c.w
+				}
+			}
+			o := dst.PixOffset(x, y)
+			for k := 0; k < 4; k++ {
+				dst.Pix[o+k] = clampUint8(px[k])
+			}
+			// premultiplied colors can't exceed alpha after rounding.
+			for k := 0; k < 3; k++ {
+				dst.Pix[o+k] = min(dst.Pix[o+k], dst.Pix[o+3])
+			}
+		}
+	}
+
+	return dst
+}
+
+// contribution è il peso di un pixel sorgente per un pixel del risultato.
+type contribution struct {
+	i int
+	w float32
+}
+
+// resampleWeights calcola per ogni pixel del risultato i pesi normalizzati dei pixel sorgente.
+func resampleWeights(src, dst int) [][]contribution {
+
+	scale := float64(src) / float64(dst)
+	radius := max(scale, 1)
+
+	weights := make([][]contribution, dst)
+
+	for x := range weights {
+
+		center := (float64(x)+0.5)*scale - 0.5
+		var sum float64
+
+		for i := int(math.Floor(center - radius)); i <= int(math.Ceil(center+radius)); i++ {
+			w := 1 - math.Abs(float64(i)-center)/radius
+			if w <= 0 {
+				continue
+			}
+			weights[x] = append(weights[x], contribution{i: min(max(i, 0), src-1), w: float32(w)})
+			sum += w
+		}
+
+		for j := range weights[x] {
+			weights[x][j].w /= float32(sum)
+		}
+	}
+
+	return weights
+}
+
+func clampUint8(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	} else if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"prova/app"
+	"prova/postgres"
+)
+
+// runImportUsers esegue il comando import-users, che importa gli user da un file CSV o JSON lines
+// e scrive su stdout il report in JSON:
+//
+//	prova import-users [-format csv|jsonl] [-all-or-nothing] [-batch-size n] file
+//
+// Con - come file le righe sono lette da stdin, il formato è dedotto dall'estensione se non indicato.
+func runImportUsers(ctx context.Context, cfg Config, args []string) error {
+
+	fs := flag.NewFlagSet("import-users", flag.ContinueOnError)
+	format := fs.String("format", "", "file format, csv or jsonl")
+	var opts app.UserImportOptions
+	fs.BoolVar(&opts.AllOrNothing, "all-or-nothing", false, "import all the rows in a single transaction, or none of them")
+	fs.IntVar(&opts.BatchSize, "batch-size", 0, "rows inserted with a single query")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		return app.Errorf(app.EINVALID, "Usage: import-users [-format csv|jsonl] [-all-or-nothing] [-batch-size n] file")
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	src, err := app.NewUserImportSource(f, *format)
+	if err != nil {
+		return err
+	}
+
+	db := postgres.NewDB(cfg.PostgresURL)
+	if err := db.Open(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := postgres.NewUserService(db).ImportUsers(ctx, src, opts)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+
+	if !report.Committed {
+		return app.Errorf(app.EINVALID, "Import rolled back, %d rows failed", report.Failed)
+	}
+
+	return nil
+}
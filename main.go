@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"prova/app"
 	"prova/http"
@@ -18,37 +20,112 @@ func main() {
 	ctx, cancel := context.WithCancel(app.Background())
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() { <-c; cancel() }()
 
-	postgresDB := postgres.NewDB(os.Getenv("POSTGRES_URL"))
+	cfg, err := ParseConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import-users" {
+		if err := runImportUsers(ctx, cfg, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, app.ErrorMessage(err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	postgresDB := postgres.NewDB(cfg.PostgresURL)
 
 	if err := postgresDB.Open(); err!= nil{
 		panic(err)
 	}
 	defer postgresDB.Close()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "5000"
-	}
-
 	postgresUserService := postgres.NewUserService(postgresDB)
+	postgresAdminService := postgres.NewAdminService(postgresDB)
+	postgresAuditLogService := postgres.NewAuditLogService(postgresDB)
 
 	logger := log15.New()
 
+	fileStorage, err := cfg.FileStorage()
+	if err != nil {
+		panic(err)
+	}
+
+	postgresFileService := postgres.NewFileService(postgresDB, fileStorage)
+	postgresFileService.Logger = logger.New("module", "files")
+
+	postgresEventService := postgres.NewEventService(postgresDB)
+	postgresEventService.Logger = logger.New("module", "events")
+
+	postgresWebhookService := postgres.NewWebhookService(postgresDB)
+	postgresWebhookService.Logger = logger.New("module", "webhooks")
+	postgresWebhookService.Sender = http.NewWebhookClient(10 * time.Second)
+
+	postgresEventService.Subscribe(app.EventTypeAll, postgresWebhookService.HandleEvent)
+
+	if err := postgresEventService.Open(); err != nil {
+		panic(err)
+	}
+
+	if err := postgresWebhookService.Open(); err != nil {
+		panic(err)
+	}
+
+	postgresJobService := postgres.NewJobService(postgresDB)
+	postgresJobService.Logger = logger.New("module", "jobs")
+	postgresJobService.Workers = cfg.JobWorkers
+	postgresJobService.MaxAttempts = cfg.JobMaxAttempts
+	postgresJobService.DrainTimeout = cfg.JobDrainTimeout
+
+	postgresJobService.Handle(app.JobKindProcessImage, postgresFileService.ProcessImageJob)
+
+	if err := postgresJobService.Open(); err != nil {
+		panic(err)
+	}
+
+	postgresSchedulerService := postgres.NewSchedulerService(postgresDB)
+	postgresSchedulerService.Logger = logger.New("module", "scheduler")
+
+	if err := scheduleMaintenanceTasks(
+		postgresSchedulerService,
+		postgresEventService,
+		postgresJobService,
+		postgresWebhookService,
+		postgresUserService,
+		postgresAdminService,
+		cfg,
+		postgresSchedulerService.Logger,
+	); err != nil {
+		panic(err)
+	}
+
+	if err := postgresSchedulerService.Open(); err != nil {
+		panic(err)
+	}
+
 	server := http.NewServerAPI()
 
-	server.Addr = fmt.Sprintf(":%s", port)
-	server.BaseURL = os.Getenv("BASE_URL")
+	server.Addr = fmt.Sprintf(":%s", cfg.Port)
+	server.BaseURL = cfg.BaseURL
 	server.LogService = logger.New("module", "http")
 	server.UserService = postgresUserService
+	server.AdminService = postgresAdminService
+	server.WebhookService = postgresWebhookService
+	server.AuditLogService = postgresAuditLogService
+	server.FileService = postgresFileService
+	server.SessionSecret = []byte(cfg.SessionSecret)
+	server.TrustedProxies = cfg.TrustedProxies
+	server.DevMode = cfg.DevMode
+	server.TemplatesDir = cfg.TemplatesDir
 
 	if err := server.Open(); err != nil {
 		panic(err)
 	}
 
-	logger.Info("Starting server", "port", port)
+	logger.Info("Starting server", "port", cfg.Port)
 
 	<-ctx.Done()
 
@@ -56,6 +133,23 @@ func main() {
 		panic(err)
 	}
 
+	if err := postgresSchedulerService.Close(); err != nil {
+		panic(err)
+	}
+
+	// drain the running jobs before closing the services they may depend on.
+	if err := postgresJobService.Close(); err != nil {
+		panic(err)
+	}
+
+	if err := postgresEventService.Close(); err != nil {
+		panic(err)
+	}
+
+	if err := postgresWebhookService.Close(); err != nil {
+		panic(err)
+	}
+
 	logger.Info("Closing server")
 
 }
@@ -2,15 +2,44 @@ package postgres
 
 import (
 	"context"
-	"fmt"
+	"database/sql"
+	"errors"
 	"prova/app"
-	"strings"
+	"prova/common"
+	"slices"
+	"time"
 
 	"prova/postgres/query"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 var _ app.AdminService = (*AdminService)(nil)
 
+// adminColumns sono le colonne lette da findAdmins e updateAdmin, nell'ordine di scansione.
+var adminColumns = []string{
+	"admin.id",
+	"admin.name",
+	"admin.surname",
+	"admin.email",
+	"admin.password",
+	"admin.active",
+	"admin.version",
+	"admin.created_at",
+	"admin.updated_at",
+	"admin.deleted_at",
+}
+
+// adminSortColumns mappa i campi ordinabili di app.AdminFilter sulle colonne.
+var adminSortColumns = map[string]string{
+	"id":         "admin.id",
+	"name":       "admin.name",
+	"surname":    "admin.surname",
+	"email":      "admin.email",
+	"created_at": "admin.created_at",
+	"updated_at": "admin.updated_at",
+}
+
 type AdminService struct {
 	db *DB
 }
@@ -56,6 +85,18 @@ func (s *AdminService) DeleteAdmin(ctx context.Context, id int64) error {
 	return nil
 }
 
+// FindAdmins implements app.AdminService.
+func (s *AdminService) FindAdmins(ctx context.Context, filter app.AdminFilter) ([]*app.Admin, int, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	return findAdmins(ctx, tx, filter)
+}
+
 // FindAdminByID implements app.AdminService.
 func (s *AdminService) FindAdminByID(ctx context.Context, id int64) (*app.Admin, error) {
 
@@ -73,6 +114,27 @@ func (s *AdminService) FindAdminByID(ctx context.Context, id int64) (*app.Admin,
 	return admin, nil
 }
 
+// AuthenticateAdmin implements app.AdminService.
+func (s *AdminService) AuthenticateAdmin(ctx context.Context, email, password string) (*app.Admin, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	admins, _, err := findAdmins(ctx, tx, app.AdminFilter{Email: &email})
+	if err != nil {
+		return nil, err
+	} else if len(admins) == 0 || !admins[0].Active {
+		return nil, app.Errorf(app.EUNAUTHORIZED, "Invalid credentials")
+	} else if err := bcrypt.CompareHashAndPassword([]byte(admins[0].Password), []byte(password)); err != nil {
+		return nil, app.Errorf(app.EUNAUTHORIZED, "Invalid credentials")
+	}
+
+	return admins[0], nil
+}
+
 func (s *AdminService) UpdateAdmin(ctx context.Context, id int64, upd app.AdminUpdate) (*app.Admin, error) {
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -91,6 +153,49 @@ func (s *AdminService) UpdateAdmin(ctx context.Context, id int64, upd app.AdminU
 	return admin, nil
 }
 
+// RestoreAdmin implements app.AdminService.
+func (s *AdminService) RestoreAdmin(ctx context.Context, id int64) (*app.Admin, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	admin, err := restoreAdmin(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	} else if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return admin, nil
+}
+
+// PurgeAdmins elimina definitivamente gli amministratori eliminati da più di retention, restituisce il numero di righe eliminate.
+func (s *AdminService) PurgeAdmins(ctx context.Context, retention time.Duration) (int64, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before := tx.now.Add(-retention)
+
+	stmt, args := query.Delete("admin").Where(query.Expr("deleted_at < ?", before)).Build()
+
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, app.Errorf(app.EINTERNAL, "Error purging admin: %v", err)
+	} else if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
 func createAdmin(ctx context.Context, tx *Tx, crt app.AdminCreate) (*app.Admin, error) {
 
 	bcryptedPassword, err := HashPassword(crt.Password)
@@ -99,29 +204,40 @@ func createAdmin(ctx context.Context, tx *Tx, crt app.AdminCreate) (*app.Admin,
 	}
 
 	admin := &app.Admin{
-		Name:     crt.Name,
-		Email:    crt.Email,
-		Surname:  crt.Surname,
-		Password: string(bcryptedPassword),
-		Active:   true,
+		Name:      crt.Name,
+		Email:     crt.Email,
+		Surname:   crt.Surname,
+		Password:  string(bcryptedPassword),
+		Active:    true,
+		Version:   1,
+		CreatedAt: tx.Now(),
+		UpdatedAt: tx.Now(),
 	}
 
 	if err := admin.Validate(); err != nil {
 		return nil, err
 	}
 
-	if err := tx.QueryRowContext(ctx, `
-		INSERT INTO admin (name, surname, email, password, active)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
-	`, admin.Name, admin.Email, admin.Password, admin.Active, admin.Surname).Scan(&admin.ID); err != nil {
+	stmt, args := query.Insert("admin").
+		Columns("name", "surname", "email", "password", "active", "version", "created_at", "updated_at").
+		Values(admin.Name, admin.Surname, admin.Email, admin.Password, admin.Active, admin.Version, admin.CreatedAt, admin.UpdatedAt).
+		Returning("id").
+		Build()
+
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&admin.ID); err != nil {
 		return nil, app.Errorf(app.EINTERNAL, "Error creating admin: %v", err)
 	}
 
+	if err := createAuditLog(ctx, tx, app.AuditActionCreate, app.AuditEntityAdmin, admin.ID, nil, admin); err != nil {
+		return nil, err
+	} else if err := publishEvent(ctx, tx, app.EventTypeAdminCreated, admin.ID, adminEventPayload(admin)); err != nil {
+		return nil, err
+	}
+
 	return admin, nil
 }
 
-// deleteAdmin elimina un amministratore.
+// deleteAdmin elimina un amministratore impostando deleted_at, la riga viene rimossa dal task di purge dopo il periodo di retention.
 func deleteAdmin(ctx context.Context, tx *Tx, id int64) error {
 
 	admin, err := findAdminByID(ctx, tx, id)
@@ -129,16 +245,72 @@ func deleteAdmin(ctx context.Context, tx *Tx, id int64) error {
 		return err
 	}
 
-	if _, err := tx.ExecContext(ctx, `
-		DELETE FROM admin
-		WHERE id = $1
-	`, admin.ID); err != nil {
+	before := *admin
+	admin.DeletedAt, admin.UpdatedAt = &tx.now, tx.Now()
+	admin.Version++
+
+	stmt, args := query.Update("admin").
+		Set("deleted_at", admin.DeletedAt).
+		Set("updated_at", admin.UpdatedAt).
+		Set("version", query.Expr("version + 1")).
+		Where(query.Eq("id", admin.ID)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
 		return app.Errorf(app.EINTERNAL, "Error deleting admin: %v", err)
 	}
 
+	if err := createAuditLog(ctx, tx, app.AuditActionDelete, app.AuditEntityAdmin, admin.ID, &before, admin); err != nil {
+		return err
+	} else if err := publishEvent(ctx, tx, app.EventTypeAdminDeleted, admin.ID, adminEventPayload(admin)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// restoreAdmin ripristina l'amministratore eliminato.
+func restoreAdmin(ctx context.Context, tx *Tx, id int64) (*app.Admin, error) {
+
+	admins, _, err := findAdmins(ctx, tx, app.AdminFilter{ID: &id, Deleted: true})
+	if err != nil {
+		return nil, err
+	} else if len(admins) == 0 {
+		return nil, app.Errorf(app.ENOTFOUND, "Deleted admin not found")
+	}
+
+	admin := admins[0]
+
+	if admins, _, err := findAdmins(ctx, tx, app.AdminFilter{Email: &admin.Email}); err != nil {
+		return nil, err
+	} else if len(admins) > 0 {
+		return nil, app.Errorf(app.ECONFLICT, "Email already in use")
+	}
+
+	before := *admin
+	admin.DeletedAt, admin.UpdatedAt = nil, tx.Now()
+	admin.Version++
+
+	stmt, args := query.Update("admin").
+		Set("deleted_at", query.Expr("NULL")).
+		Set("updated_at", admin.UpdatedAt).
+		Set("version", query.Expr("version + 1")).
+		Where(query.Eq("id", admin.ID)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error restoring admin: %v", err)
+	}
+
+	if err := createAuditLog(ctx, tx, app.AuditActionRestore, app.AuditEntityAdmin, admin.ID, &before, admin); err != nil {
+		return nil, err
+	} else if err := publishEvent(ctx, tx, app.EventTypeAdminRestored, admin.ID, adminEventPayload(admin)); err != nil {
+		return nil, err
+	}
+
+	return admin, nil
+}
+
 // findAdminByID cerca un amministratore per ID.
 func findAdminByID(ctx context.Context, tx *Tx, id int64) (*app.Admin, error) {
 
@@ -155,33 +327,52 @@ func findAdminByID(ctx context.Context, tx *Tx, id int64) (*app.Admin, error) {
 // findAdmins cerca gli amministratori, restituisce il numero totale di risultati al netto della paginazione.
 func findAdmins(ctx context.Context, tx *Tx, filter app.AdminFilter) (_ []*app.Admin, n int, err error) {
 
-	where, args := []string{"1 = 1"}, []any{}
-
-	counterParameter := 1
+	where := []query.Cond{}
 
 	if v := filter.ID; v != nil {
-		where, args = append(where, fmt.Sprintf("admin.id = $%d", counterParameter)), append(args, *v)
-		counterParameter += 1
+		where = append(where, query.Eq("admin.id", *v))
 	}
 
 	if v := filter.Email; v != nil {
-		where, args = append(where, fmt.Sprintf("admin.email = $%d", counterParameter)), append(args, *v)
-		counterParameter += 1
-	}
-
-	rows, err := tx.QueryContext(ctx, `
-		SELECT
-			admin.id,
-			admin.name,
-			admin.surname,
-			admin.email,
-			admin.password,
-			admin.active,
-			COUNT(*) OVER() AS total_count
-		FROM admin
-		WHERE `+strings.Join(where, " AND ")+`
-		ORDER BY admin.id DESC
-	`+query.FormatLimitPage(filter.Limit, filter.Page), args...)
+		where = append(where, query.Eq("admin.email", *v))
+	}
+
+	if filter.Deleted {
+		where = append(where, query.Expr("admin.deleted_at IS NOT NULL"))
+	} else if !filter.IncludeDeleted {
+		where = append(where, query.Expr("admin.deleted_at IS NULL"))
+	}
+
+	if v := filter.CreatedAfter; v != nil {
+		where = append(where, query.Expr("admin.created_at >= ?", v.UTC()))
+	}
+
+	if v := filter.CreatedBefore; v != nil {
+		where = append(where, query.Expr("admin.created_at < ?", v.UTC()))
+	}
+
+	page := pagination{
+		SortBy:  filter.SortBy,
+		SortDir: filter.SortDir,
+		After:   filter.After,
+		Before:  filter.Before,
+		Page:    filter.Page,
+		Limit:   filter.Limit,
+	}
+
+	b := query.Select(adminColumns...).
+		Column(totalCount("admin", where, page, filter.SkipCount)).
+		From("admin").
+		Where(where...)
+
+	reverse, err := paginate(b, page, adminSortColumns, query.OrderTerm{Column: "admin.id", Desc: true})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt, args := b.Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
 	if err != nil {
 		return nil, 0, app.Errorf(app.EINTERNAL, "Error querying admin: %v", err)
 	}
@@ -193,14 +384,7 @@ func findAdmins(ctx context.Context, tx *Tx, filter app.AdminFilter) (_ []*app.A
 
 		var admin app.Admin
 
-		if err := rows.Scan(
-			&admin.ID,
-			&admin.Name,
-			&admin.Surname,
-			&admin.Email,
-			&admin.Password,
-			&admin.Active,
-		); err != nil {
+		if err := scanAdmin(rows, &admin, &n); err != nil {
 			return nil, 0, app.Errorf(app.EINTERNAL, "Error scanning admins: %v", err)
 		}
 
@@ -211,58 +395,105 @@ func findAdmins(ctx context.Context, tx *Tx, filter app.AdminFilter) (_ []*app.A
 		return nil, 0, app.Errorf(app.EINTERNAL, "Error iterating admins: %v", err)
 	}
 
+	if reverse {
+		slices.Reverse(admins)
+	}
+
 	return admins, n, nil
 }
 
-// updateAdmin aggiorna un amministratore.
+// updateAdmin aggiorna solo le colonne dei campi impostati in upd, ricaricando l'amministratore con RETURNING.
+// L'amministratore risultante dalla modifica viene validato prima della scrittura.
 func updateAdmin(ctx context.Context, tx *Tx, id int64, upd app.AdminUpdate) (*app.Admin, error) {
 
-	admin, err := findAdminByID(ctx, tx, id)
+	before, err := findAdminByID(ctx, tx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	if v := upd.Name; v.Set {
-		admin.Name = v.Value
-	}
-
-	if v := upd.Surname; v.Set {
-		admin.Surname = v.Value
+	if v := upd.Version; v != nil && *v != before.Version {
+		return nil, app.Errorf(app.ECONFLICT, "Admin has been modified by someone else")
 	}
 
-	if v := upd.Email; v.Set {
-
-		a, count, err := findAdmins(ctx, tx, app.AdminFilter{Email: &v.Value})
-		if err != nil {
+	if v := upd.Email; v.Set && v.Value != before.Email {
+		if admins, _, err := findAdmins(ctx, tx, app.AdminFilter{Email: &v.Value}); err != nil {
 			return nil, err
-		} else if count > 0 && a[0].ID != admin.ID {
+		} else if len(admins) > 0 {
 			return nil, app.Errorf(app.ECONFLICT, "Email already in use")
 		}
-
-		admin.Email = v.Value
 	}
+
 	if v := upd.Password; v.Set {
 		bcryptedPassword, err := HashPassword(v.Value)
 		if err != nil {
 			return nil, err
 		}
-		admin.Password = string(bcryptedPassword)
+		upd.Password.Value = string(bcryptedPassword)
 	}
 
-	if err := admin.Validate(); err != nil {
+	merged := *before
+	if err := common.ApplyPatch(&merged, upd); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error applying admin update: %v", err)
+	} else if err := merged.Validate(); err != nil {
 		return nil, err
 	}
 
-	if _, err := tx.ExecContext(ctx, `
-		UPDATE admin SET
-			name = $2,
-			surname = $3,
-			email = $4,
-			password = $5,
-		WHERE id = $1
-	`, admin.ID, admin.Name, admin.Surname, admin.Email, admin.Password); err != nil {
+	b := query.Update("admin").SetPatch(upd)
+	if b.Empty() {
+		return before, nil
+	}
+
+	b.Set("updated_at", tx.Now()).
+		Set("version", query.Expr("version + 1")).
+		Where(query.Eq("id", id), query.Expr("deleted_at IS NULL")).
+		Returning(adminColumns...)
+
+	// the version check in the WHERE clause protects against concurrent updates committed after the read.
+	if v := upd.Version; v != nil {
+		b.Where(query.Eq("version", *v))
+	}
+
+	stmt, args := b.Build()
+
+	var admin app.Admin
+
+	if err := scanAdmin(tx.QueryRowContext(ctx, stmt, args...), &admin); errors.Is(err, sql.ErrNoRows) {
+		if upd.Version != nil {
+			return nil, app.Errorf(app.ECONFLICT, "Admin has been modified by someone else")
+		}
+		return nil, app.Errorf(app.ENOTFOUND, "Admin not found")
+	} else if err != nil {
 		return nil, app.Errorf(app.EINTERNAL, "Error updating admin: %v", err)
 	}
 
-	return admin, nil
+	if err := createAuditLog(ctx, tx, app.AuditActionUpdate, app.AuditEntityAdmin, admin.ID, before, &admin); err != nil {
+		return nil, err
+	} else if err := publishEvent(ctx, tx, app.EventTypeAdminUpdated, admin.ID, adminEventPayload(&admin)); err != nil {
+		return nil, err
+	}
+
+	return &admin, nil
+}
+
+// scanAdmin legge nell'amministratore una riga con le colonne adminColumns, seguite dalle eventuali colonne in extra.
+func scanAdmin(row interface{ Scan(...any) error }, admin *app.Admin, extra ...any) error {
+	return row.Scan(append([]any{
+		&admin.ID,
+		&admin.Name,
+		&admin.Surname,
+		&admin.Email,
+		&admin.Password,
+		&admin.Active,
+		&admin.Version,
+		&admin.CreatedAt,
+		&admin.UpdatedAt,
+		&admin.DeletedAt,
+	}, extra...)...)
+}
+
+// adminEventPayload restituisce una copia dell'amministratore senza password da usare come payload degli eventi.
+func adminEventPayload(admin *app.Admin) app.Admin {
+	a := *admin
+	a.Password = ""
+	return a
 }
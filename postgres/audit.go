@@ -0,0 +1,215 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"prova/app"
+	"prova/postgres/query"
+)
+
+// auditRedacted sostituisce il valore dei campi sensibili nell'audit log.
+const auditRedacted = "[REDACTED]"
+
+// auditRedactedFields sono i campi JSON il cui valore non viene mai salvato nell'audit log.
+var auditRedactedFields = map[string]bool{
+	"password": true,
+}
+
+var _ app.AuditLogService = (*AuditLogService)(nil)
+
+type AuditLogService struct {
+	db *DB
+}
+
+func NewAuditLogService(db *DB) *AuditLogService {
+	return &AuditLogService{db: db}
+}
+
+// FindAuditLogs implements app.AuditLogService.
+func (s *AuditLogService) FindAuditLogs(ctx context.Context, filter app.AuditLogFilter) ([]*app.AuditLog, int, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	return findAuditLogs(ctx, tx, filter)
+}
+
+// createAuditLog registra la modifica di un'entità nella transazione passata, l'attore, l'IP e il request ID
+// sono letti dal context. before è nil per le creazioni, after è nil per le eliminazioni.
+func createAuditLog(ctx context.Context, tx *Tx, action, entityType string, entityID int64, before, after any) error {
+
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		return err
+	}
+
+	log := &app.AuditLog{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    changes,
+		IP:         app.RemoteIPFromContext(ctx),
+		RequestID:  app.RequestIDFromContext(ctx),
+		CreatedAt:  tx.now,
+	}
+
+	if admin := app.AdminFromContext(ctx); admin != nil {
+		log.ActorID = &admin.ID
+	}
+
+	stmt, args := query.Insert("audit_log").
+		Columns("actor_id", "action", "entity_type", "entity_id", "changes", "ip", "request_id", "created_at").
+		Values(log.ActorID, log.Action, log.EntityType, log.EntityID, string(log.Changes), log.IP, log.RequestID, log.CreatedAt).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error creating audit log: %v", err)
+	}
+
+	return nil
+}
+
+// auditChanges restituisce il diff JSON dei campi modificati tra before e after nel formato
+// {"field": {"before": ..., "after": ...}}, i campi sensibili sono oscurati.
+func auditChanges(before, after any) (json.RawMessage, error) {
+
+	b, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]map[string]any{}
+
+	for k, v := range b {
+		if w, ok := a[k]; !ok || !reflect.DeepEqual(v, w) {
+			changes[k] = map[string]any{"before": v, "after": w}
+		}
+	}
+
+	for k, w := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = map[string]any{"before": nil, "after": w}
+		}
+	}
+
+	// sensitive fields are compared before redacting, so a password change is still recorded.
+	for k, c := range changes {
+		if !auditRedactedFields[k] {
+			continue
+		}
+		for _, side := range []string{"before", "after"} {
+			if v := c[side]; v != nil && v != "" {
+				c[side] = auditRedacted
+			}
+		}
+	}
+
+	buf, err := json.Marshal(changes)
+	if err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error encoding audit changes: %v", err)
+	}
+
+	return buf, nil
+}
+
+// auditFields converte l'entità in una mappa dei suoi campi JSON.
+func auditFields(v any) (map[string]any, error) {
+
+	fields := map[string]any{}
+
+	if rv := reflect.ValueOf(v); !rv.IsValid() || (rv.Kind() == reflect.Pointer && rv.IsNil()) {
+		return fields, nil
+	}
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error encoding audit entity: %v", err)
+	} else if err := json.Unmarshal(buf, &fields); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error decoding audit entity: %v", err)
+	}
+
+	return fields, nil
+}
+
+// findAuditLogs cerca le voci dell'audit log, restituisce il numero totale di risultati al netto della paginazione.
+func findAuditLogs(ctx context.Context, tx *Tx, filter app.AuditLogFilter) (_ []*app.AuditLog, n int, err error) {
+
+	b := query.Select(
+		"audit_log.id",
+		"audit_log.actor_id",
+		"audit_log.action",
+		"audit_log.entity_type",
+		"audit_log.entity_id",
+		"audit_log.changes",
+		"audit_log.ip",
+		"audit_log.request_id",
+		"audit_log.created_at",
+		"COUNT(*) OVER() AS total_count",
+	).From("audit_log")
+
+	if v := filter.ActorID; v != nil {
+		b.Where(query.Eq("audit_log.actor_id", *v))
+	}
+
+	if v := filter.Action; v != nil {
+		b.Where(query.Eq("audit_log.action", *v))
+	}
+
+	if v := filter.EntityType; v != nil {
+		b.Where(query.Eq("audit_log.entity_type", *v))
+	}
+
+	if v := filter.EntityID; v != nil {
+		b.Where(query.Eq("audit_log.entity_id", *v))
+	}
+
+	stmt, args := b.OrderBy(query.OrderTerm{Column: "audit_log.id", Desc: true}).
+		Page(filter.Limit, filter.Page).
+		Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error querying audit log: %v", err)
+	}
+	defer rows.Close()
+
+	logs := []*app.AuditLog{}
+
+	for rows.Next() {
+
+		var log app.AuditLog
+
+		if err := rows.Scan(
+			&log.ID,
+			&log.ActorID,
+			&log.Action,
+			&log.EntityType,
+			&log.EntityID,
+			&log.Changes,
+			&log.IP,
+			&log.RequestID,
+			&log.CreatedAt,
+			&n,
+		); err != nil {
+			return nil, 0, app.Errorf(app.EINTERNAL, "Error scanning audit log: %v", err)
+		}
+
+		logs = append(logs, &log)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error iterating audit log: %v", err)
+	}
+
+	return logs, n, nil
+}
@@ -0,0 +1,320 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"prova/app"
+	"prova/common"
+	"prova/postgres/query"
+
+	log "github.com/inconshreveable/log15"
+)
+
+var _ app.EventService = (*EventService)(nil)
+
+// EventService consegna ai subscriber gli eventi scritti nella tabella outbox.
+// Gli eventi sono inseriti nella stessa transazione della modifica, quindi non vengono persi
+// in caso di crash dopo il commit; la consegna è at-least-once.
+type EventService struct {
+	db *DB
+
+	mu          sync.RWMutex
+	subscribers map[string][]app.EventHandler
+
+	cancel func()
+	wg     sync.WaitGroup
+
+	// PollInterval is the time between two polls of the outbox table.
+	PollInterval time.Duration
+	// BatchSize is the max number of events locked for each poll.
+	BatchSize int
+	// MaxAttempts is the number of deliveries after which an event is marked as failed.
+	MaxAttempts int
+	// MinBackoff & MaxBackoff bound the exponential delay between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	Logger log.Logger
+}
+
+func NewEventService(db *DB) *EventService {
+	return &EventService{
+		db:           db,
+		subscribers:  map[string][]app.EventHandler{},
+		PollInterval: 1 * time.Second,
+		BatchSize:    100,
+		MaxAttempts:  10,
+		MinBackoff:   1 * time.Second,
+		MaxBackoff:   1 * time.Hour,
+		Logger:       log.Root(),
+	}
+}
+
+// Subscribe implements app.EventService.
+func (s *EventService) Subscribe(eventType string, h app.EventHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[eventType] = append(s.subscribers[eventType], h)
+}
+
+// Open starts the dispatcher goroutine.
+func (s *EventService) Open() error {
+
+	ctx, cancel := context.WithCancel(s.db.ctx)
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.monitor(ctx)
+	}()
+
+	return nil
+}
+
+// Close stops the dispatcher and waits for the running poll to finish.
+func (s *EventService) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// PurgeEvents elimina gli eventi consegnati da più di retention, restituisce il numero di eventi eliminati.
+func (s *EventService) PurgeEvents(ctx context.Context, retention time.Duration) (int64, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before := tx.now.Add(-retention)
+
+	stmt, args := query.Delete("outbox").Where(query.Expr("delivered_at < ?", before)).Build()
+
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, app.Errorf(app.EINTERNAL, "Error purging outbox: %v", err)
+	} else if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// monitor esegue il polling della tabella outbox finché il context non viene cancellato.
+func (s *EventService) monitor(ctx context.Context) {
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// keep polling without waiting the ticker while the batch is full.
+		for {
+			n, err := s.dispatch(ctx)
+			if err != nil {
+				app.LogErr(s.Logger, err)
+				break
+			} else if n < s.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
+
+// dispatch blocca un batch di eventi pendenti con FOR UPDATE SKIP LOCKED, così più istanze
+// possono girare in parallelo, e li consegna ai subscriber. Restituisce il numero di eventi processati.
+func (s *EventService) dispatch(ctx context.Context) (int, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	events, attempts, err := findPendingEvents(ctx, tx, s.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// subscribers receive the outbox transaction through the context, so their writes are committed
+	// together with the delivery; each event runs in a savepoint to discard the writes of a failed delivery.
+	txCtx := app.NewContextWithTx(ctx, tx)
+
+	for i, e := range events {
+
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT outbox_event`); err != nil {
+			return 0, app.Errorf(app.EINTERNAL, "Error creating savepoint: %v", err)
+		}
+
+		if err := s.deliver(txCtx, e); err != nil {
+
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT outbox_event`); err != nil {
+				return 0, app.Errorf(app.EINTERNAL, "Error rolling back savepoint: %v", err)
+			}
+
+			app.LogErr(s.Logger, app.Errorf(app.ErrorCode(err), "Error delivering event %d (%s): %s", e.ID, e.Type, app.ErrorMessage(err)))
+
+			if err := markEventFailed(ctx, tx, e.ID, attempts[i]+1, s.MaxAttempts, tx.now.Add(common.ExponentialBackoff(attempts[i]+1, s.MinBackoff, s.MaxBackoff)), err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT outbox_event`); err != nil {
+			return 0, app.Errorf(app.EINTERNAL, "Error releasing savepoint: %v", err)
+		} else if err := markEventDelivered(ctx, tx, e.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(events), nil
+}
+
+// deliver passa l'evento a tutti i subscriber del tipo e a quelli di EventTypeAll.
+func (s *EventService) deliver(ctx context.Context, e *app.Event) error {
+
+	s.mu.RLock()
+	handlers := append(append([]app.EventHandler{}, s.subscribers[e.Type]...), s.subscribers[app.EventTypeAll]...)
+	s.mu.RUnlock()
+
+	for _, h := range handlers {
+		if err := h(ctx, *e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publishEvent scrive un evento nella tabella outbox all'interno della transazione passata.
+func publishEvent(ctx context.Context, tx *Tx, eventType string, entityID int64, payload any) error {
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return app.Errorf(app.EINTERNAL, "Error encoding event payload: %v", err)
+	}
+
+	stmt, args := query.Insert("outbox").
+		Columns("type", "entity_id", "payload", "available_at", "created_at").
+		Values(eventType, entityID, string(b), tx.now, tx.now).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error publishing event: %v", err)
+	}
+
+	return nil
+}
+
+// findPendingEvents restituisce gli eventi da consegnare bloccandone le righe, insieme ai tentativi già effettuati.
+func findPendingEvents(ctx context.Context, tx *Tx, limit int) (_ []*app.Event, attempts []int, err error) {
+
+	stmt, args := query.Select(
+		"outbox.id",
+		"outbox.type",
+		"outbox.entity_id",
+		"outbox.payload",
+		"outbox.created_at",
+		"outbox.attempts",
+	).
+		From("outbox").
+		Where(
+			query.Expr("outbox.delivered_at IS NULL"),
+			query.Expr("outbox.failed_at IS NULL"),
+			query.Expr("outbox.available_at <= ?", tx.now),
+		).
+		OrderBy(query.OrderTerm{Column: "outbox.id"}).
+		Limit(limit, 0).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, nil, app.Errorf(app.EINTERNAL, "Error querying outbox: %v", err)
+	}
+	defer rows.Close()
+
+	events := []*app.Event{}
+
+	for rows.Next() {
+
+		var e app.Event
+		var n int
+
+		if err := rows.Scan(
+			&e.ID,
+			&e.Type,
+			&e.EntityID,
+			&e.Payload,
+			&e.CreatedAt,
+			&n,
+		); err != nil {
+			return nil, nil, app.Errorf(app.EINTERNAL, "Error scanning outbox: %v", err)
+		}
+
+		events, attempts = append(events, &e), append(attempts, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, app.Errorf(app.EINTERNAL, "Error iterating outbox: %v", err)
+	}
+
+	return events, attempts, nil
+}
+
+// markEventDelivered segna l'evento come consegnato.
+func markEventDelivered(ctx context.Context, tx *Tx, id int64) error {
+
+	stmt, args := query.Update("outbox").
+		Set("delivered_at", tx.now).
+		Set("attempts", query.Expr("attempts + 1")).
+		Where(query.Eq("id", id)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error updating outbox: %v", err)
+	}
+
+	return nil
+}
+
+// markEventFailed registra un tentativo fallito, rischedulando l'evento o marcandolo come fallito
+// se ha raggiunto il numero massimo di tentativi.
+func markEventFailed(ctx context.Context, tx *Tx, id int64, attempts, maxAttempts int, retryAt time.Time, cause error) error {
+
+	var failedAt *time.Time
+	if attempts >= maxAttempts {
+		failedAt = &tx.now
+	}
+
+	stmt, args := query.Update("outbox").
+		Set("attempts", attempts).
+		Set("available_at", retryAt).
+		Set("last_error", app.ErrorMessage(cause)).
+		Set("failed_at", failedAt).
+		Where(query.Eq("id", id)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error updating outbox: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,419 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"prova/app"
+	"prova/postgres/query"
+
+	log "github.com/inconshreveable/log15"
+)
+
+var _ app.FileService = (*FileService)(nil)
+
+// fileColumns sono le colonne lette da findFiles, nell'ordine di scansione.
+var fileColumns = []string{
+	"files.id",
+	"files.owner_type",
+	"files.owner_id",
+	"files.kind",
+	"files.filename",
+	"files.mime_type",
+	"files.size",
+	"files.checksum",
+	"files.storage_key",
+	"files.created_at",
+	"files.variants",
+}
+
+// processImageMaxAttempts è il numero di tentativi del job che elabora le immagini.
+const processImageMaxAttempts = 3
+
+// processImagePayload è il payload del job app.JobKindProcessImage.
+type processImagePayload struct {
+	FileID int64 `json:"file_id"`
+}
+
+// FileService registra i metadati dei file nella tabella files e ne salva il contenuto nello Storage.
+// Il contenuto è scritto prima della transazione ed eliminato dopo il commit, un errore lascia al più
+// un contenuto orfano ma mai dei metadati senza contenuto.
+type FileService struct {
+	db      *DB
+	storage app.FileStorage
+
+	Logger log.Logger
+}
+
+func NewFileService(db *DB, storage app.FileStorage) *FileService {
+	return &FileService{db: db, storage: storage, Logger: log.Root()}
+}
+
+// CreateFile implements app.FileService.
+// Un nuovo avatar sostituisce quello precedente del proprietario.
+func (s *FileService) CreateFile(ctx context.Context, upl app.FileUpload) (*app.File, error) {
+
+	if err := upl.Validate(); err != nil {
+		return nil, err
+	} else if err := authorizeFileOwner(ctx, upl.OwnerType, upl.OwnerID); err != nil {
+		return nil, err
+	}
+
+	key, err := newStorageKey(upl.OwnerType, upl.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	if err := s.storage.Put(ctx, key, io.TeeReader(io.LimitReader(upl.Body, upl.Size), hash), upl.Size, upl.MimeType); err != nil {
+		return nil, err
+	}
+
+	file := &app.File{
+		OwnerType:  upl.OwnerType,
+		OwnerID:    upl.OwnerID,
+		Kind:       upl.Kind,
+		Filename:   path.Base(upl.Filename),
+		MimeType:   upl.MimeType,
+		Size:       upl.Size,
+		Checksum:   hex.EncodeToString(hash.Sum(nil)),
+		StorageKey: key,
+	}
+
+	replaced, err := s.createFile(ctx, file)
+	if err != nil {
+		s.deleteContent(key)
+		return nil, err
+	}
+
+	for _, f := range replaced {
+		s.deleteFileContent(f)
+	}
+
+	return file, nil
+}
+
+// createFile registra il file in una transazione, restituendo gli avatar sostituiti.
+func (s *FileService) createFile(ctx context.Context, file *app.File) (replaced []*app.File, err error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// admins upload on behalf of any owner, which may not exist.
+	if err := checkFileOwner(ctx, tx, file.OwnerType, file.OwnerID); err != nil {
+		return nil, err
+	}
+
+	if file.Kind == app.FileKindAvatar {
+		if replaced, _, err = findFiles(ctx, tx, app.FileFilter{OwnerType: &file.OwnerType, OwnerID: &file.OwnerID, Kind: &file.Kind}); err != nil {
+			return nil, err
+		}
+		for _, f := range replaced {
+			if err := deleteFile(ctx, tx, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := createFile(ctx, tx, file); err != nil {
+		return nil, err
+	}
+
+	if file.Kind == app.FileKindAvatar {
+		if _, err := createJob(ctx, tx, app.JobKindProcessImage, processImagePayload{FileID: file.ID}, time.Time{}, processImageMaxAttempts); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return replaced, nil
+}
+
+// FindFileByID implements app.FileService.
+func (s *FileService) FindFileByID(ctx context.Context, id int64) (*app.File, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findAuthorizedFileByID(ctx, tx, id)
+}
+
+// FindFiles implements app.FileService.
+// Gli user vedono solo i propri file, qualunque proprietario sia indicato nel filtro.
+func (s *FileService) FindFiles(ctx context.Context, filter app.FileFilter) ([]*app.File, int, error) {
+
+	if app.AdminFromContext(ctx) == nil {
+		user := app.UserFromContext(ctx)
+		if user == nil {
+			return nil, 0, app.Errorf(app.EUNAUTHORIZED, "Authentication required")
+		}
+		ownerType := app.FileOwnerUser
+		filter.OwnerType, filter.OwnerID = &ownerType, &user.ID
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	return findFiles(ctx, tx, filter)
+}
+
+// OpenFile implements app.FileService.
+func (s *FileService) OpenFile(ctx context.Context, id int64, variant string) (*app.File, io.ReadSeekCloser, error) {
+
+	file, err := s.FindFileByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := file.StorageKey
+	if variant != "" {
+		if _, ok := file.Variants[variant]; !ok {
+			return nil, nil, app.Errorf(app.ENOTFOUND, "File variant not found")
+		}
+		key = file.VariantStorageKey(variant)
+	}
+
+	r, err := s.storage.Open(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, r, nil
+}
+
+// DeleteFile implements app.FileService.
+func (s *FileService) DeleteFile(ctx context.Context, id int64) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	file, err := findAuthorizedFileByID(ctx, tx, id)
+	if err != nil {
+		return err
+	} else if err := deleteFile(ctx, tx, file); err != nil {
+		return err
+	} else if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.deleteFileContent(file)
+
+	return nil
+}
+
+// deleteFileContent elimina il contenuto di un file eliminato e delle sue varianti.
+func (s *FileService) deleteFileContent(file *app.File) {
+	s.deleteContent(file.StorageKey)
+	for name := range file.Variants {
+		s.deleteContent(file.VariantStorageKey(name))
+	}
+}
+
+// deleteContent elimina il contenuto di un file i cui metadati non esistono più, un errore viene solo registrato.
+func (s *FileService) deleteContent(key string) {
+	if err := s.storage.Delete(context.Background(), key); err != nil {
+		app.LogErr(s.Logger, app.Errorf(app.ErrorCode(err), "Error deleting file content %s: %s", key, app.ErrorMessage(err)))
+	}
+}
+
+// authorizeFileOwner verifica che l'utente autenticato possa accedere ai file del proprietario,
+// gli amministratori accedono a tutti i file e gli user solo ai propri.
+func authorizeFileOwner(ctx context.Context, ownerType string, ownerID int64) error {
+
+	if app.AdminFromContext(ctx) != nil {
+		return nil
+	}
+
+	user := app.UserFromContext(ctx)
+	if user == nil {
+		return app.Errorf(app.EUNAUTHORIZED, "Authentication required")
+	} else if ownerType != app.FileOwnerUser || ownerID != user.ID {
+		return app.Errorf(app.EFORBIDDEN, "Access denied")
+	}
+
+	return nil
+}
+
+// checkFileOwner verifica che il proprietario di un nuovo file esista.
+func checkFileOwner(ctx context.Context, tx *Tx, ownerType string, ownerID int64) error {
+
+	var err error
+	switch ownerType {
+	case app.FileOwnerUser:
+		_, err = findUserByID(ctx, tx, ownerID)
+	case app.FileOwnerAdmin:
+		_, err = findAdminByID(ctx, tx, ownerID)
+	}
+
+	return err
+}
+
+// newStorageKey genera una chiave casuale per il contenuto di un file del proprietario.
+func newStorageKey(ownerType string, ownerID int64) (string, error) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", app.Errorf(app.EINTERNAL, "Error generating storage key: %v", err)
+	}
+
+	return fmt.Sprintf("%s/%d/%s", ownerType, ownerID, hex.EncodeToString(b)), nil
+}
+
+func createFile(ctx context.Context, tx *Tx, file *app.File) error {
+
+	file.CreatedAt = tx.Now()
+
+	stmt, args := query.Insert("files").
+		Columns("owner_type", "owner_id", "kind", "filename", "mime_type", "size", "checksum", "storage_key", "created_at").
+		Values(file.OwnerType, file.OwnerID, file.Kind, file.Filename, file.MimeType, file.Size, file.Checksum, file.StorageKey, file.CreatedAt).
+		Returning("id").
+		Build()
+
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&file.ID); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error creating file: %v", err)
+	}
+
+	if err := createAuditLog(ctx, tx, app.AuditActionCreate, app.AuditEntityFile, file.ID, nil, file); err != nil {
+		return err
+	}
+
+	return publishEvent(ctx, tx, app.EventTypeFileCreated, file.ID, file)
+}
+
+func deleteFile(ctx context.Context, tx *Tx, file *app.File) error {
+
+	stmt, args := query.Delete("files").Where(query.Eq("id", file.ID)).Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error deleting file: %v", err)
+	}
+
+	if err := createAuditLog(ctx, tx, app.AuditActionDelete, app.AuditEntityFile, file.ID, file, nil); err != nil {
+		return err
+	}
+
+	return publishEvent(ctx, tx, app.EventTypeFileDeleted, file.ID, file)
+}
+
+// findAuthorizedFileByID cerca un file accessibile dall'utente autenticato, i file di altri
+// proprietari risultano inesistenti per non rivelarne l'esistenza.
+func findAuthorizedFileByID(ctx context.Context, tx *Tx, id int64) (*app.File, error) {
+
+	file, err := findFileByID(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	} else if err := authorizeFileOwner(ctx, file.OwnerType, file.OwnerID); err != nil {
+		return nil, app.Errorf(app.ENOTFOUND, "File not found")
+	}
+
+	return file, nil
+}
+
+func findFileByID(ctx context.Context, tx *Tx, id int64) (*app.File, error) {
+
+	files, _, err := findFiles(ctx, tx, app.FileFilter{ID: &id})
+	if err != nil {
+		return nil, err
+	} else if len(files) == 0 {
+		return nil, app.Errorf(app.ENOTFOUND, "File not found")
+	}
+
+	return files[0], nil
+}
+
+// findFiles cerca i file, restituisce il numero totale di risultati al netto della paginazione.
+func findFiles(ctx context.Context, tx *Tx, filter app.FileFilter) (_ []*app.File, n int, err error) {
+
+	where := []query.Cond{}
+
+	if v := filter.ID; v != nil {
+		where = append(where, query.Eq("files.id", *v))
+	}
+
+	if v := filter.OwnerType; v != nil {
+		where = append(where, query.Eq("files.owner_type", *v))
+	}
+
+	if v := filter.OwnerID; v != nil {
+		where = append(where, query.Eq("files.owner_id", *v))
+	}
+
+	if v := filter.OwnerIDs; v != nil {
+		where = append(where, query.Any("files.owner_id", v))
+	}
+
+	if v := filter.Kind; v != nil {
+		where = append(where, query.Eq("files.kind", *v))
+	}
+
+	stmt, args := query.Select(fileColumns...).
+		Column(query.Expr("COUNT(*) OVER()")).
+		From("files").
+		Where(where...).
+		OrderBy(query.OrderTerm{Column: "files.id", Desc: true}).
+		Page(filter.Limit, filter.Page).
+		Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error querying file: %v", err)
+	}
+	defer rows.Close()
+
+	files := []*app.File{}
+
+	for rows.Next() {
+
+		var file app.File
+		var variants []byte
+
+		if err := rows.Scan(
+			&file.ID,
+			&file.OwnerType,
+			&file.OwnerID,
+			&file.Kind,
+			&file.Filename,
+			&file.MimeType,
+			&file.Size,
+			&file.Checksum,
+			&file.StorageKey,
+			&file.CreatedAt,
+			&variants,
+			&n,
+		); err != nil {
+			return nil, 0, app.Errorf(app.EINTERNAL, "Error scanning file: %v", err)
+		} else if err := json.Unmarshal(variants, &file.Variants); err != nil {
+			return nil, 0, app.Errorf(app.EINTERNAL, "Error decoding file variants: %v", err)
+		}
+
+		files = append(files, &file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error iterating file: %v", err)
+	}
+
+	return files, n, nil
+}
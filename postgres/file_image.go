@@ -0,0 +1,207 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"path"
+	"strings"
+
+	"prova/app"
+	"prova/imaging"
+	"prova/postgres/query"
+)
+
+// ProcessImageJob è l'handler del job app.JobKindProcessImage. Sostituisce il contenuto dell'immagine
+// con la versione ruotata e senza metadati EXIF, e ne genera le varianti. Il job è idempotente,
+// un'immagine con le varianti già generate o eliminata nel frattempo è ignorata.
+func (s *FileService) ProcessImageJob(ctx context.Context, job *app.Job) error {
+
+	var payload processImagePayload
+	if err := job.UnmarshalPayload(&payload); err != nil {
+		return err
+	}
+
+	file, err := s.findFileByID(ctx, payload.FileID)
+	if app.ErrorCode(err) == app.ENOTFOUND {
+		return nil
+	} else if err != nil {
+		return err
+	} else if len(file.Variants) > 0 {
+		return nil
+	}
+
+	r, err := s.storage.Open(ctx, file.StorageKey)
+	if err != nil {
+		return err
+	}
+	img, format, err := imaging.Decode(r)
+	r.Close()
+	if app.ErrorCode(err) == app.EINVALID {
+		// the content won't change, another attempt would fail in the same way.
+		app.LogErr(s.Logger, app.Errorf(app.EINVALID, "Image %d can't be processed: %s", file.ID, app.ErrorMessage(err)))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	key, err := newStorageKey(file.OwnerType, file.OwnerID)
+	if err != nil {
+		return err
+	}
+
+	processed := *file
+	processed.StorageKey = key
+	processed.MimeType = imaging.MimeType(format)
+	processed.Filename = strings.TrimSuffix(file.Filename, path.Ext(file.Filename)) + "." + strings.TrimPrefix(processed.MimeType, "image/")
+	processed.Variants = map[string]*app.FileVariant{}
+
+	// the content stored so far is removed unless the processed image is saved.
+	var stored []string
+	defer func() {
+		for _, key := range stored {
+			s.deleteContent(key)
+		}
+	}()
+
+	for _, v := range app.AvatarVariants {
+
+		resized := imaging.Fit(img, v.MaxSize)
+
+		variant, err := s.putImage(ctx, processed.VariantStorageKey(v.Name), resized, format)
+		if err != nil {
+			return err
+		}
+		stored = append(stored, processed.VariantStorageKey(v.Name))
+
+		variant.Width, variant.Height = resized.Bounds().Dx(), resized.Bounds().Dy()
+		processed.Variants[v.Name] = variant
+	}
+
+	original, err := s.putImage(ctx, key, img, format)
+	if err != nil {
+		return err
+	}
+	stored = append(stored, key)
+
+	processed.Size, processed.Checksum = original.Size, original.Checksum
+
+	if ok, err := s.updateProcessedImage(ctx, file, &processed); err != nil {
+		return err
+	} else if !ok {
+		// the file has been deleted or replaced while it was processed.
+		return nil
+	}
+
+	stored = nil
+	s.deleteContent(file.StorageKey)
+
+	return nil
+}
+
+// putImage codifica e salva un'immagine, restituendo la variante con dimensione e checksum.
+func (s *FileService) putImage(ctx context.Context, key string, img image.Image, format string) (*app.FileVariant, error) {
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, format); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	v := &app.FileVariant{
+		MimeType: imaging.MimeType(format),
+		Size:     int64(buf.Len()),
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+
+	if err := s.storage.Put(ctx, key, &buf, v.Size, v.MimeType); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// updateProcessedImage registra l'immagine elaborata se il file ha ancora il contenuto originale,
+// restituisce false se nel frattempo è stato eliminato o modificato.
+func (s *FileService) updateProcessedImage(ctx context.Context, before, after *app.File) (bool, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	variants, err := json.Marshal(after.Variants)
+	if err != nil {
+		return false, app.Errorf(app.EINTERNAL, "Error encoding file variants: %v", err)
+	}
+
+	stmt, args := query.Update("files").
+		Set("storage_key", after.StorageKey).
+		Set("filename", after.Filename).
+		Set("mime_type", after.MimeType).
+		Set("size", after.Size).
+		Set("checksum", after.Checksum).
+		Set("variants", string(variants)).
+		Where(query.Eq("id", before.ID), query.Eq("storage_key", before.StorageKey)).
+		Build()
+
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return false, app.Errorf(app.EINTERNAL, "Error updating file: %v", err)
+	} else if n, err := res.RowsAffected(); err != nil {
+		return false, app.Errorf(app.EINTERNAL, "Error updating file: %v", err)
+	} else if n == 0 {
+		return false, nil
+	}
+
+	if err := createAuditLog(ctx, tx, app.AuditActionUpdate, app.AuditEntityFile, after.ID, before, after); err != nil {
+		return false, err
+	} else if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// findFileByID cerca un file senza verificare l'utente autenticato, per i job.
+func (s *FileService) findFileByID(ctx context.Context, id int64) (*app.File, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findFileByID(ctx, tx, id)
+}
+
+// attachUserAvatars imposta l'avatar degli user con una sola query.
+func attachUserAvatars(ctx context.Context, tx *Tx, users []*app.User) error {
+
+	if len(users) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*app.User, len(users))
+	ids := make([]int64, len(users))
+	for i, user := range users {
+		byID[user.ID], ids[i] = user, user.ID
+	}
+
+	ownerType, kind := app.FileOwnerUser, app.FileKindAvatar
+
+	files, _, err := findFiles(ctx, tx, app.FileFilter{OwnerType: &ownerType, OwnerIDs: ids, Kind: &kind})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		byID[f.OwnerID].Avatar = f
+	}
+
+	return nil
+}
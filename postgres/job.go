@@ -0,0 +1,545 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"prova/app"
+	"prova/common"
+	"prova/postgres/query"
+
+	log "github.com/inconshreveable/log15"
+)
+
+var _ app.JobService = (*JobService)(nil)
+
+// JobService implementa una coda di job su Postgres con un pool di worker.
+// Ogni worker prende in carico un job alla volta con FOR UPDATE SKIP LOCKED e lo esegue fuori dalla transazione,
+// un job rimasto in esecuzione oltre Timeout (ad esempio per un crash) viene ripreso da un altro worker.
+type JobService struct {
+	db *DB
+
+	mu       sync.RWMutex
+	handlers map[string]app.JobHandler
+
+	cancel     func() // stops the workers from taking new jobs
+	cancelJobs func() // cancels the running jobs
+	wg         sync.WaitGroup
+
+	// Workers is the number of jobs executed concurrently.
+	Workers int
+	// PollInterval is the time a worker waits when the queue is empty.
+	PollInterval time.Duration
+	// MaxAttempts is the default number of attempts after which a job is dead-lettered.
+	MaxAttempts int
+	// MinBackoff & MaxBackoff bound the exponential delay between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Timeout is the max execution time of a job, after which it's considered abandoned and executed again.
+	Timeout time.Duration
+	// DrainTimeout is the time given by Close to running jobs before they are canceled.
+	DrainTimeout time.Duration
+
+	Logger log.Logger
+}
+
+func NewJobService(db *DB) *JobService {
+	return &JobService{
+		db:           db,
+		handlers:     map[string]app.JobHandler{},
+		Workers:      4,
+		PollInterval: 1 * time.Second,
+		MaxAttempts:  5,
+		MinBackoff:   5 * time.Second,
+		MaxBackoff:   1 * time.Hour,
+		Timeout:      10 * time.Minute,
+		DrainTimeout: 30 * time.Second,
+		Logger:       log.Root(),
+	}
+}
+
+// Handle implements app.JobService.
+func (s *JobService) Handle(kind string, h app.JobHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[kind] = h
+}
+
+// Open starts the worker pool.
+func (s *JobService) Open() error {
+
+	if s.Workers < 1 {
+		return app.Errorf(app.EINVALID, "Job workers must be at least 1")
+	}
+
+	ctx, cancel := context.WithCancel(s.db.ctx)
+	jobCtx, cancelJobs := context.WithCancel(s.db.ctx)
+	s.cancel, s.cancelJobs = cancel, cancelJobs
+
+	for i := 0; i < s.Workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.work(ctx, jobCtx)
+		}()
+	}
+
+	return nil
+}
+
+// Close stops the workers and waits for the running jobs to finish, jobs still running after DrainTimeout
+// are canceled and released without counting the attempt, so they will be executed again.
+func (s *JobService) Close() error {
+
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(s.DrainTimeout):
+		s.Logger.Warn("Job drain timeout exceeded, canceling running jobs")
+		s.cancelJobs()
+		<-done
+	}
+
+	s.cancelJobs()
+
+	return nil
+}
+
+// Enqueue implements app.JobService.
+func (s *JobService) Enqueue(ctx context.Context, kind string, payload any, runAt time.Time) (*app.Job, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	job, err := createJob(ctx, tx, kind, payload, runAt, s.MaxAttempts)
+	if err != nil {
+		return nil, err
+	} else if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// FindJobs implements app.JobService.
+func (s *JobService) FindJobs(ctx context.Context, filter app.JobFilter) ([]*app.Job, int, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	return findJobs(ctx, tx, filter)
+}
+
+// RetryJob implements app.JobService.
+func (s *JobService) RetryJob(ctx context.Context, id int64) (*app.Job, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	job, err := retryJob(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	} else if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// PurgeJobs elimina i job completati con successo da più di retention, restituisce il numero di job eliminati.
+// Dead jobs are kept until they are retried or removed manually.
+func (s *JobService) PurgeJobs(ctx context.Context, retention time.Duration) (int64, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before := tx.now.Add(-retention)
+
+	stmt, args := query.Delete("jobs").
+		Where(query.Eq("status", app.JobStatusSucceeded), query.Expr("finished_at < ?", before)).
+		Build()
+
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, app.Errorf(app.EINTERNAL, "Error purging jobs: %v", err)
+	} else if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// work prende in carico ed esegue i job finché il context non viene cancellato.
+func (s *JobService) work(ctx, jobCtx context.Context) {
+	for {
+
+		job, err := s.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			app.LogErr(s.Logger, err)
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.PollInterval):
+			}
+			continue
+		}
+
+		s.run(jobCtx, job)
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// claim prende in carico il prossimo job eseguibile, restituisce nil se la coda è vuota.
+func (s *JobService) claim(ctx context.Context) (*app.Job, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	job, err := claimJob(ctx, tx, tx.now.Add(s.Timeout))
+	if err != nil || job == nil {
+		return nil, err
+	} else if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// run esegue il job e ne salva l'esito.
+func (s *JobService) run(ctx context.Context, job *app.Job) {
+
+	logger := s.Logger.New("job_id", job.ID, "job_kind", job.Kind, "attempt", job.Attempts)
+
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// the job has been abandoned by a worker on its last attempt.
+		err = app.Errorf(app.EINTERNAL, "Job abandoned after %d attempts", job.MaxAttempts)
+	} else {
+		err = s.execute(ctx, job)
+	}
+
+	if err != nil {
+		app.LogErr(logger, app.Errorf(app.ErrorCode(err), "Error executing job: %s", app.ErrorMessage(err)))
+	}
+
+	// the result is saved even if the job has been canceled by the drain timeout.
+	tx, txErr := s.db.BeginTx(s.db.ctx, nil)
+	if txErr != nil {
+		app.LogErr(logger, txErr)
+		return
+	}
+	defer tx.Rollback()
+
+	// a job canceled by Close didn't fail, it's released to be executed again by the next worker.
+	if ctx.Err() != nil {
+		if txErr := releaseJob(s.db.ctx, tx, job); txErr != nil {
+			app.LogErr(logger, txErr)
+		} else if txErr := tx.Commit(); txErr != nil {
+			app.LogErr(logger, txErr)
+		}
+		return
+	}
+
+	if txErr := finishJob(s.db.ctx, tx, job, err, tx.now.Add(common.ExponentialBackoff(job.Attempts, s.MinBackoff, s.MaxBackoff))); txErr != nil {
+		app.LogErr(logger, txErr)
+		return
+	} else if txErr := tx.Commit(); txErr != nil {
+		app.LogErr(logger, txErr)
+		return
+	}
+
+	if job.Status == app.JobStatusDead {
+		logger.Error("Job dead-lettered", "error", job.LastError)
+	}
+}
+
+// execute chiama l'handler del job trasformando un eventuale panic in errore.
+func (s *JobService) execute(ctx context.Context, job *app.Job) (err error) {
+
+	s.mu.RLock()
+	h, ok := s.handlers[job.Kind]
+	s.mu.RUnlock()
+
+	if !ok {
+		return app.Errorf(app.ENOTIMPLEMENTED, "No handler for job %q", job.Kind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = app.Errorf(app.EINTERNAL, "Job panicked: %v", r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	return h(ctx, job)
+}
+
+func createJob(ctx context.Context, tx *Tx, kind string, payload any, runAt time.Time, maxAttempts int) (*app.Job, error) {
+
+	if kind == "" {
+		return nil, app.Errorf(app.EINVALID, "Job kind is required")
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, app.Errorf(app.EINVALID, "Error encoding job payload: %v", err)
+	}
+
+	if runAt.IsZero() {
+		runAt = tx.now
+	}
+
+	job := &app.Job{
+		Kind:        kind,
+		Payload:     b,
+		Status:      app.JobStatusPending,
+		MaxAttempts: maxAttempts,
+		RunAt:       runAt.UTC(),
+		CreatedAt:   tx.now,
+	}
+
+	stmt, args := query.Insert("jobs").
+		Columns("kind", "payload", "status", "max_attempts", "run_at", "created_at").
+		Values(job.Kind, string(job.Payload), job.Status, job.MaxAttempts, job.RunAt, job.CreatedAt).
+		Returning("id").
+		Build()
+
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&job.ID); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error creating job: %v", err)
+	}
+
+	return job, nil
+}
+
+// claimJob segna come in esecuzione il prossimo job eseguibile: un job pendente da eseguire
+// o un job in esecuzione il cui lock è scaduto.
+func claimJob(ctx context.Context, tx *Tx, lockedUntil time.Time) (*app.Job, error) {
+
+	next := query.Select("jobs.id").
+		From("jobs").
+		Where(query.Or(
+			query.And(query.Eq("jobs.status", app.JobStatusPending), query.Expr("jobs.run_at <= ?", tx.now)),
+			query.And(query.Eq("jobs.status", app.JobStatusRunning), query.Expr("jobs.locked_until <= ?", tx.now)),
+		)).
+		OrderBy(query.OrderTerm{Column: "jobs.run_at"}, query.OrderTerm{Column: "jobs.id"}).
+		Limit(1, 0).
+		Suffix("FOR UPDATE SKIP LOCKED")
+
+	stmt, args := query.Update("jobs").
+		Set("status", app.JobStatusRunning).
+		Set("attempts", query.Expr("attempts + 1")).
+		Set("locked_until", lockedUntil).
+		Where(query.Expr("id = ?", next.Expr())).
+		Returning("id", "kind", "payload", "status", "attempts", "max_attempts", "last_error", "run_at", "created_at").
+		Build()
+
+	var job app.Job
+
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.CreatedAt,
+	); errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error claiming job: %v", err)
+	}
+
+	return &job, nil
+}
+
+// finishJob salva l'esito dell'esecuzione: in caso di errore il job viene rischedulato a retryAt
+// oppure spostato nei dead-letter se ha esaurito i tentativi.
+func finishJob(ctx context.Context, tx *Tx, job *app.Job, cause error, retryAt time.Time) error {
+
+	switch {
+	case cause == nil:
+		job.Status, job.LastError, job.FinishedAt = app.JobStatusSucceeded, "", &tx.now
+	case job.Attempts >= job.MaxAttempts:
+		job.Status, job.LastError, job.FinishedAt = app.JobStatusDead, app.ErrorMessage(cause), &tx.now
+	default:
+		job.Status, job.LastError, job.RunAt = app.JobStatusPending, app.ErrorMessage(cause), retryAt
+	}
+
+	stmt, args := query.Update("jobs").
+		Set("status", job.Status).
+		Set("last_error", job.LastError).
+		Set("run_at", job.RunAt).
+		Set("finished_at", job.FinishedAt).
+		Set("locked_until", query.Expr("NULL")).
+		Where(query.Eq("id", job.ID)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error updating job: %v", err)
+	}
+
+	return nil
+}
+
+// releaseJob rimette in coda un job in esecuzione annullando il tentativo in corso.
+func releaseJob(ctx context.Context, tx *Tx, job *app.Job) error {
+
+	job.Status, job.Attempts = app.JobStatusPending, job.Attempts-1
+
+	stmt, args := query.Update("jobs").
+		Set("status", job.Status).
+		Set("attempts", job.Attempts).
+		Set("locked_until", query.Expr("NULL")).
+		Where(query.Eq("id", job.ID)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error updating job: %v", err)
+	}
+
+	return nil
+}
+
+// retryJob rimette in coda un job dead-lettered.
+func retryJob(ctx context.Context, tx *Tx, id int64) (*app.Job, error) {
+
+	jobs, _, err := findJobs(ctx, tx, app.JobFilter{ID: &id})
+	if err != nil {
+		return nil, err
+	} else if len(jobs) == 0 {
+		return nil, app.Errorf(app.ENOTFOUND, "Job not found")
+	}
+
+	job := jobs[0]
+
+	if job.Status != app.JobStatusDead {
+		return nil, app.Errorf(app.ECONFLICT, "Only dead jobs can be retried")
+	}
+
+	job.Status, job.Attempts, job.RunAt, job.FinishedAt = app.JobStatusPending, 0, tx.now, nil
+
+	stmt, args := query.Update("jobs").
+		Set("status", job.Status).
+		Set("attempts", job.Attempts).
+		Set("run_at", job.RunAt).
+		Set("finished_at", query.Expr("NULL")).
+		Where(query.Eq("id", job.ID)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error updating job: %v", err)
+	}
+
+	return job, nil
+}
+
+// findJobs cerca i job, restituisce il numero totale di risultati al netto della paginazione.
+func findJobs(ctx context.Context, tx *Tx, filter app.JobFilter) (_ []*app.Job, n int, err error) {
+
+	b := query.Select(
+		"jobs.id",
+		"jobs.kind",
+		"jobs.payload",
+		"jobs.status",
+		"jobs.attempts",
+		"jobs.max_attempts",
+		"jobs.last_error",
+		"jobs.run_at",
+		"jobs.created_at",
+		"jobs.finished_at",
+		"COUNT(*) OVER() AS total_count",
+	).From("jobs")
+
+	if v := filter.ID; v != nil {
+		b.Where(query.Eq("jobs.id", *v))
+	}
+
+	if v := filter.Kind; v != nil {
+		b.Where(query.Eq("jobs.kind", *v))
+	}
+
+	if v := filter.Status; v != nil {
+		b.Where(query.Eq("jobs.status", *v))
+	}
+
+	stmt, args := b.OrderBy(query.OrderTerm{Column: "jobs.id", Desc: true}).
+		Page(filter.Limit, filter.Page).
+		Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error querying jobs: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := []*app.Job{}
+
+	for rows.Next() {
+
+		var job app.Job
+
+		if err := rows.Scan(
+			&job.ID,
+			&job.Kind,
+			&job.Payload,
+			&job.Status,
+			&job.Attempts,
+			&job.MaxAttempts,
+			&job.LastError,
+			&job.RunAt,
+			&job.CreatedAt,
+			&job.FinishedAt,
+			&n,
+		); err != nil {
+			return nil, 0, app.Errorf(app.EINTERNAL, "Error scanning jobs: %v", err)
+		}
+
+		jobs = append(jobs, &job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error iterating jobs: %v", err)
+	}
+
+	return jobs, n, nil
+}
@@ -0,0 +1,16 @@
+CREATE TABLE jobs
+(
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    run_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX jobs_runnable_idx ON jobs (run_at, id) WHERE status IN ('pending', 'running');
@@ -0,0 +1,11 @@
+CREATE TABLE task_runs
+(
+    id BIGSERIAL PRIMARY KEY,
+    task VARCHAR(255) NOT NULL,
+    scheduled_at TIMESTAMP NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    UNIQUE (task, scheduled_at)
+);
@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE admin ADD COLUMN deleted_at TIMESTAMP;
+
+-- soft deleted users must not block the email for new accounts.
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+CREATE UNIQUE INDEX users_email_idx ON users (email) WHERE deleted_at IS NULL;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX admin_deleted_at_idx ON admin (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,14 @@
+ALTER TABLE users
+    ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'),
+    ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc');
+
+ALTER TABLE admin
+    ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'),
+    ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc');
+
+-- the defaults are only used to fill the existing rows, timestamps are set by the application from Tx.Now.
+ALTER TABLE users ALTER COLUMN created_at DROP DEFAULT, ALTER COLUMN updated_at DROP DEFAULT;
+ALTER TABLE admin ALTER COLUMN created_at DROP DEFAULT, ALTER COLUMN updated_at DROP DEFAULT;
+
+CREATE INDEX users_created_at_idx ON users (created_at);
+CREATE INDEX admin_created_at_idx ON admin (created_at);
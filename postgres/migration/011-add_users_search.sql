@@ -0,0 +1,12 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- the 'simple' configuration doesn't stem, names & emails must be matched as they are written.
+ALTER TABLE users
+    ADD COLUMN search tsvector GENERATED ALWAYS AS (
+        to_tsvector('simple', name || ' ' || surname || ' ' || replace(email, '@', ' '))
+    ) STORED;
+
+CREATE INDEX users_search_idx ON users USING GIN (search);
+
+-- trigram fallback for misspelled names, the expression must match the one used by SearchUsers.
+CREATE INDEX users_name_trgm_idx ON users USING GIN ((name || ' ' || surname) gin_trgm_ops);
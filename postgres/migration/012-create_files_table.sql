@@ -0,0 +1,15 @@
+CREATE TABLE files
+(
+    id BIGSERIAL PRIMARY KEY,
+    owner_type VARCHAR(32) NOT NULL,
+    owner_id BIGINT NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    storage_key VARCHAR(512) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX files_owner_idx ON files (owner_type, owner_id, kind);
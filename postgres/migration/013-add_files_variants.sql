@@ -0,0 +1,2 @@
+-- the resized versions of an image, by name, as generated by the process_image job.
+ALTER TABLE files ADD COLUMN variants JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,3 @@
+-- emails are unique regardless of case, as the import already checks them within a file.
+DROP INDEX users_email_idx;
+CREATE UNIQUE INDEX users_email_idx ON users (lower(email)) WHERE deleted_at IS NULL;
@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"prova/app"
+	"prova/postgres/query"
+)
+
+// pagination descrive la pagina richiesta da un filtro, per numero di pagina oppure con un cursore.
+type pagination struct {
+	SortBy, SortDir string
+	After, Before   *string
+	Page, Limit     int
+}
+
+// keyset indica se la pagina è richiesta con un cursore.
+func (p pagination) keyset() bool {
+	return p.After != nil || p.Before != nil
+}
+
+// paginate applica alla query l'ordinamento e la pagina richiesti, con un cursore aggiunge la condizione
+// keyset. Con Before le righe sono selezionate in ordine inverso, reverse indica che vanno rigirate.
+func paginate(b *query.SelectBuilder, p pagination, columns map[string]string, tieBreaker query.OrderTerm) (reverse bool, err error) {
+
+	order, err := query.ParseOrderBy(p.SortBy, p.SortDir, columns, tieBreaker)
+	if err != nil {
+		return false, err
+	}
+
+	if !p.keyset() {
+		b.OrderBy(order...).Page(p.Limit, p.Page)
+		return false, nil
+	} else if p.After != nil && p.Before != nil {
+		return false, app.Errorf(app.EINVALID, "After and Before cursors can't be used together")
+	}
+
+	s := p.After
+	if p.Before != nil {
+		s, order, reverse = p.Before, query.ReverseOrder(order), true
+	}
+
+	cursor, err := app.DecodeCursor(*s, p.SortBy, p.SortDir)
+	if err != nil {
+		return false, err
+	}
+
+	keyset, err := query.Keyset(order, cursor.Values)
+	if err != nil {
+		return false, err
+	}
+
+	b.Where(keyset).OrderBy(order...).Limit(p.Limit, 0)
+
+	return reverse, nil
+}
+
+// totalCount restituisce l'espressione del numero totale di risultati della query su table con le condizioni where,
+// con un cursore è calcolata con una subquery perché la condizione keyset seleziona solo la pagina.
+func totalCount(table string, where []query.Cond, p pagination, skip bool) query.Cond {
+	if skip {
+		return query.Expr("0")
+	} else if p.keyset() {
+		return query.Select("COUNT(*)").From(table).Where(where...).Expr()
+	}
+	return query.Expr("COUNT(*) OVER()")
+}
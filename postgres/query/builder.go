@@ -0,0 +1,518 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"prova/common"
+
+	"github.com/lib/pq"
+)
+
+// Cond is a SQL fragment with its arguments, placeholders are written as ? and they're numbered
+// as $1, $2... when the whole query is built, so fragments can be composed in any order.
+//
+// A ? in string literals, quoted identifiers, dollar-quoted strings and comments isn't a placeholder.
+// Operators containing ?, like the jsonb ?, ?| and ?&, must be escaped as ??, ??| and ??&.
+// A fragment whose placeholders don't match its arguments is a programming error and panics.
+type Cond struct {
+	sql  string
+	args []any
+}
+
+// Expr returns a SQL fragment with ? placeholders for args, a Cond argument is written
+// in place of its placeholder, e.g. Expr("id = ?", Select("id")...Expr()).
+func Expr(sql string, args ...any) Cond {
+
+	c := Cond{}
+	i := 0
+
+	c.sql = scanPlaceholders(sql, false, func(b *strings.Builder) {
+		if i >= len(args) {
+			b.WriteByte('?')
+		} else if sub, ok := args[i].(Cond); ok {
+			b.WriteString(sub.sql)
+			c.args = append(c.args, sub.args...)
+		} else {
+			b.WriteByte('?')
+			c.args = append(c.args, args[i])
+		}
+		i++
+	})
+
+	if i != len(args) {
+		panic(fmt.Sprintf("query: %q has %d placeholders for %d arguments", sql, i, len(args)))
+	}
+
+	return c
+}
+
+// Eq returns the condition column = v.
+func Eq(column string, v any) Cond {
+	return Expr(column+" = ?", v)
+}
+
+// Any returns the condition column = ANY(values), values must be a slice.
+func Any(column string, values any) Cond {
+	return Expr(column+" = ANY(?)", pq.Array(values))
+}
+
+// Exists returns the condition EXISTS sub, sub is a subquery returned by SelectBuilder.Expr.
+func Exists(sub Cond) Cond {
+	return Cond{sql: "EXISTS " + sub.sql, args: sub.args}
+}
+
+// And joins the conditions with AND, with no conditions it's always true.
+func And(conds ...Cond) Cond {
+	return join(conds, " AND ", "1 = 1")
+}
+
+// Or joins the conditions with OR, with no conditions it's always false.
+func Or(conds ...Cond) Cond {
+	return join(conds, " OR ", "1 = 0")
+}
+
+// join joins the conditions with sep in parenthesis.
+func join(conds []Cond, sep, empty string) Cond {
+
+	if len(conds) == 0 {
+		return Expr(empty)
+	} else if len(conds) == 1 {
+		return conds[0]
+	}
+
+	c := Cond{}
+	parts := make([]string, len(conds))
+	for i, cond := range conds {
+		parts[i] = "(" + cond.sql + ")"
+		c.args = append(c.args, cond.args...)
+	}
+	c.sql = strings.Join(parts, sep)
+
+	return c
+}
+
+// Build returns the SQL with numbered placeholders and its arguments.
+func (c Cond) Build() (string, []any) {
+
+	sql, n := numberPlaceholders(c.sql)
+	if n != len(c.args) {
+		panic(fmt.Sprintf("query: %q has %d placeholders for %d arguments", c.sql, n, len(c.args)))
+	}
+
+	return sql, c.args
+}
+
+// numberPlaceholders replaces the ? placeholders with $1, $2... and the escaped ?? with ?,
+// it returns the number of placeholders.
+func numberPlaceholders(sql string) (string, int) {
+
+	n := 0
+
+	sql = scanPlaceholders(sql, true, func(b *strings.Builder) {
+		n++
+		fmt.Fprintf(b, "$%d", n)
+	})
+
+	return sql, n
+}
+
+// scanPlaceholders copies sql calling placeholder in place of each ? placeholder, the ? in string
+// literals, quoted identifiers, dollar-quoted strings and comments are copied as they are.
+// The escaped ?? is copied as ? if unescape is true, otherwise it's kept for the final build.
+func scanPlaceholders(sql string, unescape bool, placeholder func(b *strings.Builder)) string {
+
+	var b strings.Builder
+
+	for i := 0; i < len(sql); {
+
+		end := i + 1
+
+		switch ch := sql[i]; {
+		case ch == '\'' || ch == '"':
+			// a doubled quote closes the literal and opens another one, so it needs no special case.
+			end = closing(sql, i+1, string(ch), 1)
+		case strings.HasPrefix(sql[i:], "--"):
+			end = closing(sql, i+2, "\n", 1)
+		case strings.HasPrefix(sql[i:], "/*"):
+			end = closing(sql, i+2, "*/", 2)
+		case ch == '$':
+			if tag := dollarTag(sql[i:]); tag != "" {
+				end = closing(sql, i+len(tag), tag, len(tag))
+			}
+		case strings.HasPrefix(sql[i:], "??"):
+			if unescape {
+				b.WriteByte('?')
+			} else {
+				b.WriteString("??")
+			}
+			i += 2
+			continue
+		case ch == '?':
+			placeholder(&b)
+			i++
+			continue
+		}
+
+		b.WriteString(sql[i:end])
+		i = end
+	}
+
+	return b.String()
+}
+
+// closing returns the index after the first delim in sql from start, or the length of sql if delim is missing.
+func closing(sql string, start int, delim string, size int) int {
+	if j := strings.Index(sql[start:], delim); j >= 0 {
+		return start + j + size
+	}
+	return len(sql)
+}
+
+// dollarTag returns the opening tag of the dollar-quoted string at the start of sql, as $$ or $tag$,
+// or an empty string if sql doesn't start with one, e.g. with the positional parameter $1.
+func dollarTag(sql string) string {
+
+	for i := 1; i < len(sql); i++ {
+		switch ch := sql[i]; {
+		case ch == '$':
+			return sql[:i+1]
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || i > 1 && ch >= '0' && ch <= '9':
+		default:
+			return ""
+		}
+	}
+
+	return ""
+}
+
+// SelectBuilder builds a SELECT query.
+type SelectBuilder struct {
+	columns []Cond
+	from    Cond
+	where   []Cond
+	orderBy []Cond
+	limit   string
+	suffix  string
+}
+
+// Select starts a SELECT query for the columns.
+func Select(columns ...string) *SelectBuilder {
+	b := &SelectBuilder{}
+	for _, c := range columns {
+		b.columns = append(b.columns, Expr(c))
+	}
+	return b
+}
+
+// Column adds a column computed by an expression with arguments.
+func (b *SelectBuilder) Column(expr Cond) *SelectBuilder {
+	b.columns = append(b.columns, expr)
+	return b
+}
+
+// From sets the FROM clause, args are the arguments of its placeholders.
+func (b *SelectBuilder) From(from string, args ...any) *SelectBuilder {
+	b.from = Expr(from, args...)
+	return b
+}
+
+// Where adds conditions joined with AND to the previous ones.
+func (b *SelectBuilder) Where(conds ...Cond) *SelectBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+// OrderBy adds the order terms to the ORDER BY clause.
+func (b *SelectBuilder) OrderBy(terms ...OrderTerm) *SelectBuilder {
+	for _, t := range terms {
+		b.orderBy = append(b.orderBy, Expr(t.String()))
+	}
+	return b
+}
+
+// OrderByExpr adds expressions with arguments to the ORDER BY clause.
+func (b *SelectBuilder) OrderByExpr(exprs ...Cond) *SelectBuilder {
+	b.orderBy = append(b.orderBy, exprs...)
+	return b
+}
+
+// Limit sets the LIMIT & OFFSET clauses, as in FormatLimitOffset.
+func (b *SelectBuilder) Limit(limit, offset int) *SelectBuilder {
+	b.limit = FormatLimitOffset(limit, offset)
+	return b
+}
+
+// Page sets the LIMIT & OFFSET clauses for a page, as in FormatLimitPage.
+func (b *SelectBuilder) Page(limit, page int) *SelectBuilder {
+	b.limit = FormatLimitPage(limit, page)
+	return b
+}
+
+// Suffix adds a clause at the end of the query, e.g. FOR UPDATE.
+func (b *SelectBuilder) Suffix(suffix string) *SelectBuilder {
+	b.suffix = suffix
+	return b
+}
+
+// Expr returns the query as a fragment in parenthesis, to be used as subquery.
+func (b *SelectBuilder) Expr() Cond {
+	c := b.cond()
+	c.sql = "(" + c.sql + ")"
+	return c
+}
+
+// Build returns the SQL with numbered placeholders and its arguments.
+func (b *SelectBuilder) Build() (string, []any) {
+	return b.cond().Build()
+}
+
+func (b *SelectBuilder) cond() Cond {
+
+	var sql strings.Builder
+	var args []any
+
+	write := func(c Cond) {
+		sql.WriteString(c.sql)
+		args = append(args, c.args...)
+	}
+
+	sql.WriteString("SELECT ")
+	for i, c := range b.columns {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		write(c)
+	}
+
+	if b.from.sql != "" {
+		sql.WriteString(" FROM ")
+		write(b.from)
+	}
+
+	if len(b.where) > 0 {
+		sql.WriteString(" WHERE ")
+		write(And(b.where...))
+	}
+
+	if len(b.orderBy) > 0 {
+		sql.WriteString(" ORDER BY ")
+		for i, c := range b.orderBy {
+			if i > 0 {
+				sql.WriteString(", ")
+			}
+			write(c)
+		}
+	}
+
+	if b.limit != "" {
+		sql.WriteString(" " + b.limit)
+	}
+
+	if b.suffix != "" {
+		sql.WriteString(" " + b.suffix)
+	}
+
+	return Cond{sql: sql.String(), args: args}
+}
+
+// InsertBuilder builds an INSERT query.
+type InsertBuilder struct {
+	table     string
+	columns   []string
+	rows      [][]any
+	suffix    string
+	returning string
+}
+
+// Insert starts an INSERT query into table.
+func Insert(table string) *InsertBuilder {
+	return &InsertBuilder{table: table}
+}
+
+// Columns sets the inserted columns.
+func (b *InsertBuilder) Columns(columns ...string) *InsertBuilder {
+	b.columns = columns
+	return b
+}
+
+// Values adds a row of values, one for each column. A Cond value is written as expression.
+func (b *InsertBuilder) Values(values ...any) *InsertBuilder {
+	b.rows = append(b.rows, values)
+	return b
+}
+
+// Suffix adds a clause after the values, e.g. ON CONFLICT.
+func (b *InsertBuilder) Suffix(suffix string) *InsertBuilder {
+	b.suffix = suffix
+	return b
+}
+
+// Returning sets the RETURNING clause.
+func (b *InsertBuilder) Returning(columns ...string) *InsertBuilder {
+	b.returning = strings.Join(columns, ", ")
+	return b
+}
+
+// Build returns the SQL with numbered placeholders and its arguments.
+func (b *InsertBuilder) Build() (string, []any) {
+
+	var sql strings.Builder
+	var args []any
+
+	fmt.Fprintf(&sql, "INSERT INTO %s (%s) VALUES ", b.table, strings.Join(b.columns, ", "))
+
+	for i, row := range b.rows {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteString("(")
+		for j, v := range row {
+			if j > 0 {
+				sql.WriteString(", ")
+			}
+			c := value(v)
+			sql.WriteString(c.sql)
+			args = append(args, c.args...)
+		}
+		sql.WriteString(")")
+	}
+
+	if b.suffix != "" {
+		sql.WriteString(" " + b.suffix)
+	}
+
+	if b.returning != "" {
+		sql.WriteString(" RETURNING " + b.returning)
+	}
+
+	return Cond{sql: sql.String(), args: args}.Build()
+}
+
+// UpdateBuilder builds an UPDATE query.
+type UpdateBuilder struct {
+	table     string
+	set       []Cond
+	where     []Cond
+	returning string
+}
+
+// Update starts an UPDATE query of table.
+func Update(table string) *UpdateBuilder {
+	return &UpdateBuilder{table: table}
+}
+
+// Set assigns v to the column. A Cond value is written as expression, e.g. Expr("attempts + 1").
+func (b *UpdateBuilder) Set(column string, v any) *UpdateBuilder {
+	c := value(v)
+	c.sql = column + " = " + c.sql
+	b.set = append(b.set, c)
+	return b
+}
+
+// SetPatch assigns the set common.Patch fields of patch, a struct, to the columns named by their db tag.
+// Fields without the db tag are ignored.
+func (b *UpdateBuilder) SetPatch(patch any) *UpdateBuilder {
+
+	v := reflect.ValueOf(patch)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+
+		column := v.Type().Field(i).Tag.Get("db")
+		if column == "" {
+			continue
+		}
+
+		if p, ok := v.Field(i).Interface().(common.Patcher); ok && p.IsSet() {
+			b.Set(column, p.PatchValue())
+		}
+	}
+
+	return b
+}
+
+// Empty returns true if no column is assigned.
+func (b *UpdateBuilder) Empty() bool {
+	return len(b.set) == 0
+}
+
+// Where adds conditions joined with AND to the previous ones.
+func (b *UpdateBuilder) Where(conds ...Cond) *UpdateBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+// Returning sets the RETURNING clause.
+func (b *UpdateBuilder) Returning(columns ...string) *UpdateBuilder {
+	b.returning = strings.Join(columns, ", ")
+	return b
+}
+
+// Build returns the SQL with numbered placeholders and its arguments.
+func (b *UpdateBuilder) Build() (string, []any) {
+
+	c := Cond{}
+
+	set := make([]string, len(b.set))
+	for i, s := range b.set {
+		set[i] = s.sql
+		c.args = append(c.args, s.args...)
+	}
+
+	c.sql = "UPDATE " + b.table + " SET " + strings.Join(set, ", ")
+
+	if len(b.where) > 0 {
+		w := And(b.where...)
+		c.sql += " WHERE " + w.sql
+		c.args = append(c.args, w.args...)
+	}
+
+	if b.returning != "" {
+		c.sql += " RETURNING " + b.returning
+	}
+
+	return c.Build()
+}
+
+// DeleteBuilder builds a DELETE query.
+type DeleteBuilder struct {
+	table string
+	where []Cond
+}
+
+// Delete starts a DELETE query from table.
+func Delete(table string) *DeleteBuilder {
+	return &DeleteBuilder{table: table}
+}
+
+// Where adds conditions joined with AND to the previous ones.
+func (b *DeleteBuilder) Where(conds ...Cond) *DeleteBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+// Build returns the SQL with numbered placeholders and its arguments.
+func (b *DeleteBuilder) Build() (string, []any) {
+
+	c := Expr("DELETE FROM " + b.table)
+
+	if len(b.where) > 0 {
+		w := And(b.where...)
+		c.sql += " WHERE " + w.sql
+		c.args = w.args
+	}
+
+	return c.Build()
+}
+
+// value returns the fragment for a value, a placeholder unless v is already a Cond.
+func value(v any) Cond {
+	if c, ok := v.(Cond); ok {
+		return c
+	}
+	return Expr("?", v)
+}
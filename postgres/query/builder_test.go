@@ -0,0 +1,87 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNumberPlaceholders(t *testing.T) {
+
+	tests := []struct {
+		sql  string
+		want string
+		n    int
+	}{
+		{"a = ? AND b = ?", "a = $1 AND b = $2", 2},
+		{"a = '?' AND b = ?", "a = '?' AND b = $1", 1},
+		{"a = 'it''s ?' AND b = ?", "a = 'it''s ?' AND b = $1", 1},
+		{`"quoted?" = ?`, `"quoted?" = $1`, 1},
+		{"a = $$ ? $$ AND b = ?", "a = $$ ? $$ AND b = $1", 1},
+		{"a = $tag$ ' ? $tag$ AND b = ?", "a = $tag$ ' ? $tag$ AND b = $1", 1},
+		{"a = ? -- why?\nAND b = ?", "a = $1 -- why?\nAND b = $2", 2},
+		{"a = ? /* why? */ AND b = ?", "a = $1 /* why? */ AND b = $2", 2},
+		{"data ?? ? AND data ??| ? AND data ??& ?", "data ? $1 AND data ?| $2 AND data ?& $3", 3},
+		{"a = $1", "a = $1", 0},
+		{"a = 'unterminated ?", "a = 'unterminated ?", 0},
+	}
+
+	for _, tt := range tests {
+		if got, n := numberPlaceholders(tt.sql); got != tt.want || n != tt.n {
+			t.Errorf("numberPlaceholders(%q) = %q, %d, want %q, %d", tt.sql, got, n, tt.want, tt.n)
+		}
+	}
+}
+
+func TestExpr(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		cond     Cond
+		wantSQL  string
+		wantArgs []any
+	}{
+		{"args", Expr("a = ? AND b = ?", 1, "x"), "a = $1 AND b = $2", []any{1, "x"}},
+		{"no args", Expr("a IS NULL"), "a IS NULL", nil},
+		{"quoted", Expr("a = '?' AND b = ?", 1), "a = '?' AND b = $1", []any{1}},
+		{"escaped", Expr("data ?? ?", "key"), "data ? $1", []any{"key"}},
+		{"subquery", Expr("id = ? AND a = ?", Select("id").From("t").Where(Eq("b", 2)).Expr(), 3), "id = (SELECT id FROM t WHERE b = $1) AND a = $2", []any{2, 3}},
+		{"nested escape", And(Expr("data ?? ?", "k"), Eq("a", 1)), "(data ? $1) AND (a = $2)", []any{"k", 1}},
+		{"or", Or(Eq("a", 1), Eq("b", 2)), "(a = $1) OR (b = $2)", []any{1, 2}},
+		{"empty and", And(), "1 = 1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args := tt.cond.Build()
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %q, want %q", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestExprArgumentMismatch(t *testing.T) {
+
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"missing argument", func() { Expr("a = ? AND b = ?", 1) }},
+		{"extra argument", func() { Expr("a = ?", 1, 2) }},
+		{"jsonb operator not escaped", func() { Expr("data ? ?", "k") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			tt.fn()
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package query
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"prova/app"
+)
 
 // FormatLimitOffset returns a SQL string for a given limit & offset.
 // Clauses are only added if limit and/or offset are greater than zero.
@@ -23,3 +28,110 @@ func FormatLimitPage(limit, page int) string {
 	offset := (page - 1) * limit
 	return FormatLimitOffset(limit, offset)
 }
+
+// OrderTerm is a column of an ORDER BY clause.
+type OrderTerm struct {
+	Column string
+	Desc   bool
+}
+
+// ParseOrderBy returns the order terms for the columns mapped to the comma separated fields of sortBy,
+// sortDir holds the matching comma separated directions or a single one for all fields.
+// tieBreaker is always appended, it must be a unique column to make the order stable.
+// Fields missing from columns and directions other than asc/desc return an EINVALID error.
+func ParseOrderBy(sortBy, sortDir string, columns map[string]string, tieBreaker OrderTerm) ([]OrderTerm, error) {
+
+	if sortBy == "" {
+		return []OrderTerm{tieBreaker}, nil
+	}
+
+	fields, dirs := strings.Split(sortBy, ","), []string{}
+	if sortDir != "" {
+		dirs = strings.Split(sortDir, ",")
+	}
+
+	if len(dirs) > 1 && len(dirs) != len(fields) {
+		return nil, app.Errorf(app.EINVALID, "Sort directions don't match sort fields")
+	}
+
+	terms := make([]OrderTerm, 0, len(fields)+1)
+
+	for i, field := range fields {
+
+		column, ok := columns[strings.TrimSpace(field)]
+		if !ok {
+			return nil, app.Errorf(app.EINVALID, "Invalid sort field %q", field)
+		}
+
+		var d string
+		if len(dirs) == 1 {
+			d = dirs[0]
+		} else if len(dirs) > 1 {
+			d = dirs[i]
+		}
+
+		term := OrderTerm{Column: column}
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case "", "asc":
+		case "desc":
+			term.Desc = true
+		default:
+			return nil, app.Errorf(app.EINVALID, "Invalid sort direction %q", d)
+		}
+
+		terms = append(terms, term)
+	}
+
+	return append(terms, tieBreaker), nil
+}
+
+// ReverseOrder returns the terms with every direction flipped.
+func ReverseOrder(terms []OrderTerm) []OrderTerm {
+	reversed := make([]OrderTerm, len(terms))
+	for i, t := range terms {
+		reversed[i] = OrderTerm{Column: t.Column, Desc: !t.Desc}
+	}
+	return reversed
+}
+
+// String returns the term as written in an ORDER BY clause.
+func (t OrderTerm) String() string {
+	if t.Desc {
+		return t.Column + " DESC"
+	}
+	return t.Column + " ASC"
+}
+
+// Keyset returns the condition selecting the rows that follow values in the order of terms,
+// values holds one value for each term.
+func Keyset(terms []OrderTerm, values []string) (Cond, error) {
+
+	if len(values) != len(terms) {
+		return Cond{}, app.Errorf(app.EINVALID, "Invalid cursor")
+	}
+
+	// (a > $1) OR (a = $1 AND b < $2) OR ...
+	conds := make([]Cond, len(terms))
+	for i, t := range terms {
+
+		cond := make([]Cond, 0, i+1)
+		for j := 0; j < i; j++ {
+			cond = append(cond, Eq(terms[j].Column, values[j]))
+		}
+
+		op := " > ?"
+		if t.Desc {
+			op = " < ?"
+		}
+		cond = append(cond, Expr(t.Column+op, values[i]))
+
+		conds[i] = And(cond...)
+	}
+
+	return Or(conds...), nil
+}
+
+// EscapeLike escapes the LIKE wildcards in s so that it's matched literally.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,47 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"prova/app"
+)
+
+func TestParseOrderBy(t *testing.T) {
+
+	columns := map[string]string{"name": "users.name", "email": "users.email"}
+	id := OrderTerm{Column: "users.id"}
+
+	tests := []struct {
+		sortBy  string
+		sortDir string
+		want    []OrderTerm
+		ok      bool
+	}{
+		{"", "", []OrderTerm{id}, true},
+		{"", "desc", []OrderTerm{id}, true},
+		{"name", "", []OrderTerm{{Column: "users.name"}, id}, true},
+		{"name", "DESC", []OrderTerm{{Column: "users.name", Desc: true}, id}, true},
+		{"name,email", "desc", []OrderTerm{{Column: "users.name", Desc: true}, {Column: "users.email", Desc: true}, id}, true},
+		{"name, email", "asc, desc", []OrderTerm{{Column: "users.name"}, {Column: "users.email", Desc: true}, id}, true},
+		{"name,email", "asc,desc,asc", nil, false},
+		{"password", "", nil, false},
+		{"name", "up", nil, false},
+		{"name,", "", nil, false},
+	}
+
+	for _, tt := range tests {
+
+		got, err := ParseOrderBy(tt.sortBy, tt.sortDir, columns, id)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseOrderBy(%q, %q) error = %v, want ok %v", tt.sortBy, tt.sortDir, err, tt.ok)
+			continue
+		} else if err != nil && app.ErrorCode(err) != app.EINVALID {
+			t.Errorf("ParseOrderBy(%q, %q) error code = %s, want %s", tt.sortBy, tt.sortDir, app.ErrorCode(err), app.EINVALID)
+		}
+
+		if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseOrderBy(%q, %q) = %v, want %v", tt.sortBy, tt.sortDir, got, tt.want)
+		}
+	}
+}
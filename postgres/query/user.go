@@ -1,13 +0,0 @@
-package query
-
-
-func insertUser() string {
-	return `
-		INSERT INTO users(
-			name,
-			lastname,
-			email,
-			password,
-			phone
-		) 	VALUES ($1,$2,$3,$4,$5,$6)`
-}
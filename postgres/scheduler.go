@@ -0,0 +1,304 @@
+package postgres
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"prova/app"
+	"prova/common"
+	"prova/postgres/query"
+
+	log "github.com/inconshreveable/log15"
+)
+
+var _ app.SchedulerService = (*SchedulerService)(nil)
+
+// scheduledTask è un task registrato nello scheduler.
+type scheduledTask struct {
+	name     string
+	schedule common.CronSchedule
+	fn       app.TaskFunc
+}
+
+// SchedulerService esegue i task ricorrenti allo scoccare di ogni minuto previsto dalla loro espressione cron.
+// Ogni dyno esegue lo scheduler, ma un advisory lock di Postgres e il vincolo univoco su (task, scheduled_at)
+// garantiscono che ogni tick di un task sia eseguito da un solo dyno.
+type SchedulerService struct {
+	db *DB
+
+	mu    sync.RWMutex
+	tasks []*scheduledTask
+
+	cancel func()
+	wg     sync.WaitGroup
+
+	Logger log.Logger
+}
+
+func NewSchedulerService(db *DB) *SchedulerService {
+	return &SchedulerService{
+		db:     db,
+		Logger: log.Root(),
+	}
+}
+
+// Schedule implements app.SchedulerService.
+func (s *SchedulerService) Schedule(name, spec string, fn app.TaskFunc) error {
+
+	schedule, err := common.ParseCron(spec)
+	if err != nil {
+		return app.Errorf(app.EINVALID, "Invalid schedule for task %q: %v", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tasks {
+		if t.name == name {
+			return app.Errorf(app.EEXISTS, "Task %q already scheduled", name)
+		}
+	}
+
+	s.tasks = append(s.tasks, &scheduledTask{name: name, schedule: schedule, fn: fn})
+
+	return nil
+}
+
+// FindTaskRuns implements app.SchedulerService.
+func (s *SchedulerService) FindTaskRuns(ctx context.Context, filter app.TaskRunFilter) ([]*app.TaskRun, int, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	return findTaskRuns(ctx, tx, filter)
+}
+
+// Open starts the scheduler goroutine.
+func (s *SchedulerService) Open() error {
+
+	ctx, cancel := context.WithCancel(s.db.ctx)
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.monitor(ctx)
+	}()
+
+	return nil
+}
+
+// Close stops the scheduler and waits for the running tasks to finish.
+func (s *SchedulerService) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// monitor attende l'inizio di ogni minuto ed avvia i task previsti.
+func (s *SchedulerService) monitor(ctx context.Context) {
+	for {
+
+		now := s.db.Now().UTC()
+		tick := now.Truncate(time.Minute).Add(time.Minute)
+
+		timer := time.NewTimer(tick.Sub(now))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.mu.RLock()
+		tasks := append([]*scheduledTask{}, s.tasks...)
+		s.mu.RUnlock()
+
+		for _, task := range tasks {
+			if !task.schedule.Matches(tick) {
+				continue
+			}
+
+			s.wg.Add(1)
+			go func(task *scheduledTask) {
+				defer s.wg.Done()
+				s.run(ctx, task, tick)
+			}(task)
+		}
+	}
+}
+
+// run esegue il task per il tick passato se nessun altro dyno lo sta eseguendo o lo ha già eseguito,
+// l'advisory lock è mantenuto dalla transazione per tutta la durata del task.
+func (s *SchedulerService) run(ctx context.Context, task *scheduledTask, tick time.Time) {
+
+	logger := s.Logger.New("task", task.name, "scheduled_at", tick)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		app.LogErr(logger, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if ok, err := lockTask(ctx, tx, task.name, tick); err != nil {
+		app.LogErr(logger, err)
+		return
+	} else if !ok {
+		return
+	}
+
+	run := &app.TaskRun{
+		Task:        task.name,
+		ScheduledAt: tick,
+		StartedAt:   s.db.Now().UTC(),
+	}
+
+	err = s.execute(ctx, task)
+
+	run.FinishedAt = s.db.Now().UTC()
+
+	if err != nil {
+		run.Status, run.Error = app.TaskRunStatusFailed, app.ErrorMessage(err)
+		app.LogErr(logger, app.Errorf(app.ErrorCode(err), "Task %s failed: %s", task.name, app.ErrorMessage(err)))
+	} else {
+		run.Status = app.TaskRunStatusSucceeded
+		logger.Info("Task completed", "duration", run.FinishedAt.Sub(run.StartedAt))
+	}
+
+	if err := createTaskRun(ctx, tx, run); err != nil {
+		app.LogErr(logger, err)
+		return
+	} else if err := tx.Commit(); err != nil {
+		app.LogErr(logger, err)
+		return
+	}
+}
+
+// execute chiama la funzione del task trasformando un eventuale panic in errore.
+func (s *SchedulerService) execute(ctx context.Context, task *scheduledTask) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = app.Errorf(app.EINTERNAL, "Task panicked: %v", r)
+		}
+	}()
+
+	return task.fn(ctx)
+}
+
+// lockTask acquisisce l'advisory lock del task per la durata della transazione e verifica che il tick
+// non sia già stato eseguito, restituisce false se il task non deve essere eseguito.
+func lockTask(ctx context.Context, tx *Tx, name string, tick time.Time) (bool, error) {
+
+	h := fnv.New64a()
+	h.Write([]byte("task:" + name))
+
+	stmt, args := query.Select().Column(query.Expr("pg_try_advisory_xact_lock(?)", int64(h.Sum64()))).Build()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&locked); err != nil {
+		return false, app.Errorf(app.EINTERNAL, "Error locking task: %v", err)
+	} else if !locked {
+		return false, nil
+	}
+
+	stmt, args = query.Select().
+		Column(query.Exists(query.Select("1").
+			From("task_runs").
+			Where(query.Eq("task", name), query.Eq("scheduled_at", tick)).
+			Expr())).
+		Build()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&exists); err != nil {
+		return false, app.Errorf(app.EINTERNAL, "Error checking task run: %v", err)
+	}
+
+	return !exists, nil
+}
+
+// createTaskRun salva l'esito di un'esecuzione.
+func createTaskRun(ctx context.Context, tx *Tx, run *app.TaskRun) error {
+
+	stmt, args := query.Insert("task_runs").
+		Columns("task", "scheduled_at", "status", "error", "started_at", "finished_at").
+		Values(run.Task, run.ScheduledAt, run.Status, run.Error, run.StartedAt, run.FinishedAt).
+		Returning("id").
+		Build()
+
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&run.ID); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error creating task run: %v", err)
+	}
+
+	return nil
+}
+
+// findTaskRuns cerca le esecuzioni dei task, restituisce il numero totale di risultati al netto della paginazione.
+func findTaskRuns(ctx context.Context, tx *Tx, filter app.TaskRunFilter) (_ []*app.TaskRun, n int, err error) {
+
+	b := query.Select(
+		"task_runs.id",
+		"task_runs.task",
+		"task_runs.scheduled_at",
+		"task_runs.status",
+		"task_runs.error",
+		"task_runs.started_at",
+		"task_runs.finished_at",
+		"COUNT(*) OVER() AS total_count",
+	).From("task_runs")
+
+	if v := filter.Task; v != nil {
+		b.Where(query.Eq("task_runs.task", *v))
+	}
+
+	if v := filter.Status; v != nil {
+		b.Where(query.Eq("task_runs.status", *v))
+	}
+
+	stmt, args := b.OrderBy(query.OrderTerm{Column: "task_runs.id", Desc: true}).
+		Page(filter.Limit, filter.Page).
+		Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error querying task runs: %v", err)
+	}
+	defer rows.Close()
+
+	runs := []*app.TaskRun{}
+
+	for rows.Next() {
+
+		var run app.TaskRun
+
+		if err := rows.Scan(
+			&run.ID,
+			&run.Task,
+			&run.ScheduledAt,
+			&run.Status,
+			&run.Error,
+			&run.StartedAt,
+			&run.FinishedAt,
+			&n,
+		); err != nil {
+			return nil, 0, app.Errorf(app.EINTERNAL, "Error scanning task runs: %v", err)
+		}
+
+		runs = append(runs, &run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error iterating task runs: %v", err)
+	}
+
+	return runs, n, nil
+}
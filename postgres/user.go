@@ -2,14 +2,49 @@ package postgres
 
 import (
 	"context"
-	"fmt"
+	"database/sql"
+	"errors"
 	"prova/app"
+	"prova/common"
 	"prova/postgres/query"
+	"slices"
 	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 var _ app.UserService = (*UserService)(nil)
 
+// userColumns sono le colonne lette da findUsers, searchUsers e updateUser, nell'ordine di scansione.
+var userColumns = []string{
+	"users.id",
+	"users.name",
+	"users.surname",
+	"users.email",
+	"users.password",
+	"users.phone",
+	"users.version",
+	"users.created_at",
+	"users.updated_at",
+	"users.deleted_at",
+}
+
+// maxSearchUsersLimit è il numero massimo di risultati restituiti da SearchUsers.
+const maxSearchUsersLimit = 50
+
+// userSortColumns mappa i campi ordinabili di app.UserFilter sulle colonne.
+var userSortColumns = map[string]string{
+	"id":         "users.id",
+	"name":       "users.name",
+	"surname":    "users.surname",
+	"email":      "users.email",
+	"phone":      "users.phone",
+	"created_at": "users.created_at",
+	"updated_at": "users.updated_at",
+}
+
 type UserService struct {
 	db *DB
 }
@@ -60,7 +95,33 @@ func (s *UserService) FindUsers(ctx context.Context, filter app.UserFilter) ([]*
 	}
 	defer tx.Rollback()
 
-	return findUsers(ctx, tx, filter)
+	users, n, err := findUsers(ctx, tx, filter)
+	if err != nil {
+		return nil, 0, err
+	} else if err := attachUserAvatars(ctx, tx, users); err != nil {
+		return nil, 0, err
+	}
+
+	return users, n, nil
+}
+
+// SearchUsers implements app.UserService.
+func (s *UserService) SearchUsers(ctx context.Context, q string, limit int) ([]*app.User, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	users, err := searchUsers(ctx, tx, q, limit)
+	if err != nil {
+		return nil, err
+	} else if err := attachUserAvatars(ctx, tx, users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
 }
 
 // UpdateUser implements app.UserService.
@@ -84,6 +145,82 @@ func (s *UserService) UpdateUser(ctx context.Context, id int64, upd app.UserUpda
 	return user, nil
 }
 
+// RestoreUser implements app.UserService.
+func (s *UserService) RestoreUser(ctx context.Context, id int64) (*app.User, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, err := restoreUser(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	} else if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// AuthenticateUser implements app.UserService.
+func (s *UserService) AuthenticateUser(ctx context.Context, email, password string) (*app.User, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	users, _, err := findUsers(ctx, tx, app.UserFilter{Email: &email, SkipCount: true})
+	if err != nil {
+		return nil, err
+	} else if len(users) == 0 {
+		return nil, app.Errorf(app.EUNAUTHORIZED, "Invalid credentials")
+	} else if err := bcrypt.CompareHashAndPassword([]byte(users[0].Password), []byte(password)); err != nil {
+		return nil, app.Errorf(app.EUNAUTHORIZED, "Invalid credentials")
+	}
+
+	return users[0], nil
+}
+
+// ExportUsers implements app.UserService.
+func (s *UserService) ExportUsers(ctx context.Context, filter app.UserFilter, fn func(*app.User) error) error {
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return exportUsers(ctx, tx, filter, fn)
+}
+
+// PurgeUsers elimina definitivamente gli user eliminati da più di retention, restituisce il numero di righe eliminate.
+func (s *UserService) PurgeUsers(ctx context.Context, retention time.Duration) (int64, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before := tx.now.Add(-retention)
+
+	stmt, args := query.Delete("users").Where(query.Expr("deleted_at < ?", before)).Build()
+
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, app.Errorf(app.EINTERNAL, "Error purging users: %v", err)
+	} else if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
 func NewUserService(db *DB) *UserService {
 	return &UserService{db: db}
 }
@@ -114,29 +251,40 @@ func createUser(ctx context.Context, tx *Tx, crt app.UserCreate) (*app.User, err
 	}
 
 	user := &app.User{
-		Name:     crt.Name,
-		Email:    crt.Email,
-		Surname:  crt.Surname,
-		Password: string(bcryptedPassword),
-		Phone:    crt.Phone,
+		Name:      crt.Name,
+		Email:     crt.Email,
+		Surname:   crt.Surname,
+		Password:  string(bcryptedPassword),
+		Phone:     crt.Phone,
+		Version:   1,
+		CreatedAt: tx.Now(),
+		UpdatedAt: tx.Now(),
 	}
 
 	if err := user.Validate(); err != nil {
 		return nil, err
 	}
 
-	if err := tx.QueryRowContext(ctx, `
-		INSERT INTO users (name, surname, email, password, phone)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
-	`, user.Name, user.Surname, user.Email, user.Password, user.Phone).Scan(&user.ID); err != nil {
+	stmt, args := query.Insert("users").
+		Columns("name", "surname", "email", "password", "phone", "version", "created_at", "updated_at").
+		Values(user.Name, user.Surname, user.Email, user.Password, user.Phone, user.Version, user.CreatedAt, user.UpdatedAt).
+		Returning("id").
+		Build()
+
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&user.ID); err != nil {
 		return nil, app.Errorf(app.EINTERNAL, "Error creating user: %v", err)
 	}
 
+	if err := createAuditLog(ctx, tx, app.AuditActionCreate, app.AuditEntityUser, user.ID, nil, user); err != nil {
+		return nil, err
+	} else if err := publishEvent(ctx, tx, app.EventTypeUserCreated, user.ID, userEventPayload(user)); err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
 
-// deleteAdmin elimina un amministratore.
+// deleteUser elimina un user impostando deleted_at, la riga viene rimossa dal task di purge dopo il periodo di retention.
 func deleteUser(ctx context.Context, tx *Tx, id int64) error {
 
 	user, err := findUserByID(ctx, tx, id)
@@ -144,16 +292,146 @@ func deleteUser(ctx context.Context, tx *Tx, id int64) error {
 		return err
 	}
 
-	if _, err := tx.ExecContext(ctx, `
-		DELETE FROM user
-		WHERE id = $1
-	`, user.ID); err != nil {
+	before := *user
+	user.DeletedAt, user.UpdatedAt = &tx.now, tx.Now()
+	user.Version++
+
+	stmt, args := query.Update("users").
+		Set("deleted_at", user.DeletedAt).
+		Set("updated_at", user.UpdatedAt).
+		Set("version", query.Expr("version + 1")).
+		Where(query.Eq("id", user.ID)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
 		return app.Errorf(app.EINTERNAL, "Error deleting user: %v", err)
 	}
 
+	if err := createAuditLog(ctx, tx, app.AuditActionDelete, app.AuditEntityUser, user.ID, &before, user); err != nil {
+		return err
+	} else if err := publishEvent(ctx, tx, app.EventTypeUserDeleted, user.ID, userEventPayload(user)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// restoreUser ripristina lo user eliminato.
+func restoreUser(ctx context.Context, tx *Tx, id int64) (*app.User, error) {
+
+	users, _, err := findUsers(ctx, tx, app.UserFilter{ID: &id, Deleted: true})
+	if err != nil {
+		return nil, err
+	} else if len(users) == 0 {
+		return nil, app.Errorf(app.ENOTFOUND, "Deleted user not found")
+	}
+
+	user := users[0]
+
+	if users, _, err := findUsers(ctx, tx, app.UserFilter{Email: &user.Email}); err != nil {
+		return nil, err
+	} else if len(users) > 0 {
+		return nil, app.Errorf(app.ECONFLICT, "Email already in use")
+	}
+
+	before := *user
+	user.DeletedAt, user.UpdatedAt = nil, tx.Now()
+	user.Version++
+
+	stmt, args := query.Update("users").
+		Set("deleted_at", query.Expr("NULL")).
+		Set("updated_at", user.UpdatedAt).
+		Set("version", query.Expr("version + 1")).
+		Where(query.Eq("id", user.ID)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error restoring user: %v", err)
+	}
+
+	if err := createAuditLog(ctx, tx, app.AuditActionRestore, app.AuditEntityUser, user.ID, &before, user); err != nil {
+		return nil, err
+	} else if err := publishEvent(ctx, tx, app.EventTypeUserRestored, user.ID, userEventPayload(user)); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// searchUsers cerca gli user con la ricerca full-text sui prefissi delle parole di q, gli user trovati
+// solo per similarità di trigrammi su nome e cognome seguono quelli trovati dalla ricerca full-text.
+func searchUsers(ctx context.Context, tx *Tx, q string, limit int) ([]*app.User, error) {
+
+	if limit <= 0 || limit > maxSearchUsersLimit {
+		limit = maxSearchUsersLimit
+	}
+
+	tsquery := formatPrefixTSQuery(q)
+	if tsquery == "" {
+		return []*app.User{}, nil
+	}
+
+	text := strings.ToLower(strings.TrimSpace(q))
+
+	stmt, args := query.Select(userColumns...).
+		From("users, to_tsquery('simple', ?) AS query", tsquery).
+		Where(
+			query.Expr("users.deleted_at IS NULL"),
+			query.Or(
+				query.Expr("users.search @@ query"),
+				query.Expr("? % (users.name || ' ' || users.surname)", text),
+			),
+		).
+		OrderByExpr(
+			query.Expr("users.search @@ query DESC"),
+			query.Expr("ts_rank(users.search, query) DESC"),
+			query.Expr("similarity(?, users.name || ' ' || users.surname) DESC", text),
+			query.Expr("users.id DESC"),
+		).
+		Limit(limit, 0).
+		Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error searching users: %v", err)
+	}
+	defer rows.Close()
+
+	users := []*app.User{}
+
+	for rows.Next() {
+
+		var user app.User
+
+		if err := scanUser(rows, &user); err != nil {
+			return nil, app.Errorf(app.EINTERNAL, "Error scanning user: %v", err)
+		}
+
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error iterating user: %v", err)
+	}
+
+	return users, nil
+}
+
+// formatPrefixTSQuery converte il testo cercato in una tsquery che richiede tutte le parole come prefissi,
+// es. "mario ross" diventa "mario:* & ross:*". I caratteri diversi da lettere e cifre separano le parole.
+func formatPrefixTSQuery(q string) string {
+
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
 // findAdminByID cerca un amministratore per ID.
 func findUserByID(ctx context.Context, tx *Tx, id int64) (*app.User, error) {
 
@@ -170,33 +448,14 @@ func findUserByID(ctx context.Context, tx *Tx, id int64) (*app.User, error) {
 // findAdmins cerca gli amministratori, restituisce il numero totale di risultati al netto della paginazione.
 func findUsers(ctx context.Context, tx *Tx, filter app.UserFilter) (_ []*app.User, n int, err error) {
 
-	where, args := []string{"1 = 1"}, []any{}
-
-	counterParameter := 1
-
-	if v := filter.ID; v != nil {
-		where, args = append(where, fmt.Sprintf("users.id = $%d", counterParameter)), append(args, *v)
-		counterParameter += 1
+	b, reverse, err := findUsersQuery(filter)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if v := filter.Email; v != nil {
-		where, args = append(where, fmt.Sprintf("users.email = $%d", counterParameter)), append(args, *v)
-		counterParameter += 1
-	}
-
-	rows, err := tx.QueryContext(ctx, `
-		SELECT
-			users.id,
-			users.name,
-			users.surname,
-			users.email,
-			users.password,
-			users.phone,
-			COUNT(*) OVER() AS total_count
-		FROM users
-		WHERE `+strings.Join(where, " AND ")+`
-		ORDER BY users.id DESC
-	`+query.FormatLimitPage(filter.Limit, filter.Page), args...)
+	stmt, args := b.Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
 	if err != nil {
 		return nil, 0, app.Errorf(app.EINTERNAL, "Error querying user: %v", err)
 	}
@@ -208,15 +467,7 @@ func findUsers(ctx context.Context, tx *Tx, filter app.UserFilter) (_ []*app.Use
 
 		var user app.User
 
-		if err := rows.Scan(
-			&user.ID,
-			&user.Name,
-			&user.Surname,
-			&user.Email,
-			&user.Password,
-			&user.Phone,
-			&n,
-		); err != nil {
+		if err := scanUser(rows, &user, &n); err != nil {
 			return nil, 0, app.Errorf(app.EINTERNAL, "Error scanning user: %v", err)
 		}
 
@@ -227,67 +478,217 @@ func findUsers(ctx context.Context, tx *Tx, filter app.UserFilter) (_ []*app.Use
 		return nil, 0, app.Errorf(app.EINTERNAL, "Error iterating user: %v", err)
 	}
 
+	if reverse {
+		slices.Reverse(users)
+	}
+
 	return users, n, nil
 }
 
-// updateAdmin aggiorna un amministratore.
-func updateUser(ctx context.Context, tx *Tx, id int64, upd app.UserUpdate) (*app.User, error) {
+// exportUsers chiama fn per ogni user del filtro senza paginazione, leggendo le righe man mano dal cursore
+// della query invece di caricarle tutte in memoria.
+func exportUsers(ctx context.Context, tx *Tx, filter app.UserFilter, fn func(*app.User) error) error {
 
-	user, err := findUserByID(ctx, tx, id)
+	filter.After, filter.Before, filter.Page, filter.Limit, filter.SkipCount = nil, nil, 0, 0, true
+
+	b, _, err := findUsersQuery(filter)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if v := upd.Name; v.Set {
-		user.Name = v.Value
+	stmt, args := b.Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return app.Errorf(app.EINTERNAL, "Error querying user: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var user app.User
+		var n int
+
+		if err := scanUser(rows, &user, &n); err != nil {
+			return app.Errorf(app.EINTERNAL, "Error scanning user: %v", err)
+		}
+
+		if err := fn(&user); err != nil {
+			return err
+		}
 	}
 
-	if v := upd.Surname; v.Set {
-		user.Name = v.Value
+	if err := rows.Err(); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error iterating user: %v", err)
 	}
 
-	if v := upd.Email; v.Set {
+	return nil
+}
 
-		a, count, err := findAdmins(ctx, tx, app.AdminFilter{Email: &v.Value})
-		if err != nil {
+// findUsersQuery restituisce la query degli user del filtro, reverse indica che le righe vanno rigirate come in paginate.
+func findUsersQuery(filter app.UserFilter) (_ *query.SelectBuilder, reverse bool, err error) {
+
+	where := []query.Cond{}
+
+	if v := filter.ID; v != nil {
+		where = append(where, query.Eq("users.id", *v))
+	}
+
+	if v := filter.IDs; v != nil {
+		where = append(where, query.Any("users.id", v))
+	}
+
+	if v := filter.Email; v != nil {
+		// the expression must match the one of users_email_idx.
+		where = append(where, query.Expr("lower(users.email) = lower(?)", *v))
+	}
+
+	if v := filter.Search; v != nil && *v != "" {
+		pattern := "%" + query.EscapeLike(*v) + "%"
+		where = append(where, query.Or(
+			query.Expr("users.name ILIKE ?", pattern),
+			query.Expr("users.surname ILIKE ?", pattern),
+			query.Expr("users.email ILIKE ?", pattern),
+		))
+	}
+
+	if v := filter.PhonePrefix; v != nil && *v != "" {
+		where = append(where, query.Expr("users.phone::text LIKE ?", query.EscapeLike(*v)+"%"))
+	}
+
+	if filter.Deleted {
+		where = append(where, query.Expr("users.deleted_at IS NOT NULL"))
+	} else if !filter.IncludeDeleted {
+		where = append(where, query.Expr("users.deleted_at IS NULL"))
+	}
+
+	if v := filter.CreatedAfter; v != nil {
+		where = append(where, query.Expr("users.created_at >= ?", v.UTC()))
+	}
+
+	if v := filter.CreatedBefore; v != nil {
+		where = append(where, query.Expr("users.created_at < ?", v.UTC()))
+	}
+
+	page := pagination{
+		SortBy:  filter.SortBy,
+		SortDir: filter.SortDir,
+		After:   filter.After,
+		Before:  filter.Before,
+		Page:    filter.Page,
+		Limit:   filter.Limit,
+	}
+
+	b := query.Select(userColumns...).
+		Column(totalCount("users", where, page, filter.SkipCount)).
+		From("users").
+		Where(where...)
+
+	reverse, err = paginate(b, page, userSortColumns, query.OrderTerm{Column: "users.id", Desc: true})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return b, reverse, nil
+}
+
+// updateUser aggiorna solo le colonne dei campi impostati in upd, ricaricando lo user con RETURNING.
+// Lo user risultante dalla modifica viene validato prima della scrittura.
+func updateUser(ctx context.Context, tx *Tx, id int64, upd app.UserUpdate) (*app.User, error) {
+
+	before, err := findUserByID(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := upd.Version; v != nil && *v != before.Version {
+		return nil, app.Errorf(app.ECONFLICT, "User has been modified by someone else")
+	}
+
+	if v := upd.Email; v.Set && v.Value != before.Email {
+		// the user may be changing only the case of its own email.
+		if users, _, err := findUsers(ctx, tx, app.UserFilter{Email: &v.Value}); err != nil {
 			return nil, err
-		} else if count > 0 && a[0].ID != user.ID {
+		} else if len(users) > 0 && users[0].ID != id {
 			return nil, app.Errorf(app.ECONFLICT, "Email already in use")
 		}
-
-		user.Email = v.Value
 	}
+
 	if v := upd.Password; v.Set {
 		bcryptedPassword, err := HashPassword(v.Value)
 		if err != nil {
 			return nil, err
 		}
-		user.Password = string(bcryptedPassword)
+		upd.Password.Value = string(bcryptedPassword)
 	}
 
-	// if v := upd.Phone; v.Set {
-	// 	user.Name = v.Value
-	// }
-
-	if err := user.Validate(); err != nil {
+	merged := *before
+	if err := common.ApplyPatch(&merged, upd); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error applying user update: %v", err)
+	} else if err := merged.Validate(); err != nil {
 		return nil, err
 	}
 
-	if _, err := tx.ExecContext(ctx, `
-		UPDATE admins SET
-			name = $2,
-			surname = $6,
-			email = $3,
-			password = $4,
-			phone = $5,
-		WHERE id = $1
-	`, user.ID, user.Name, user.Email, user.Password, user.Phone, user.Surname); err != nil {
+	b := query.Update("users").SetPatch(upd)
+	if b.Empty() {
+		return before, nil
+	}
+
+	b.Set("updated_at", tx.Now()).
+		Set("version", query.Expr("version + 1")).
+		Where(query.Eq("id", id), query.Expr("deleted_at IS NULL")).
+		Returning(userColumns...)
+
+	// the version check in the WHERE clause protects against concurrent updates committed after the read.
+	if v := upd.Version; v != nil {
+		b.Where(query.Eq("version", *v))
+	}
+
+	stmt, args := b.Build()
+
+	var user app.User
+
+	if err := scanUser(tx.QueryRowContext(ctx, stmt, args...), &user); errors.Is(err, sql.ErrNoRows) {
+		if upd.Version != nil {
+			return nil, app.Errorf(app.ECONFLICT, "User has been modified by someone else")
+		}
+		return nil, app.Errorf(app.ENOTFOUND, "User not found")
+	} else if err != nil {
 		return nil, app.Errorf(app.EINTERNAL, "Error updating user: %v", err)
 	}
 
-	return user, nil
+	if err := createAuditLog(ctx, tx, app.AuditActionUpdate, app.AuditEntityUser, user.ID, before, &user); err != nil {
+		return nil, err
+	} else if err := publishEvent(ctx, tx, app.EventTypeUserUpdated, user.ID, userEventPayload(&user)); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// scanUser legge nello user una riga con le colonne userColumns, seguite dalle eventuali colonne in extra.
+func scanUser(row interface{ Scan(...any) error }, user *app.User, extra ...any) error {
+	return row.Scan(append([]any{
+		&user.ID,
+		&user.Name,
+		&user.Surname,
+		&user.Email,
+		&user.Password,
+		&user.Phone,
+		&user.Version,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DeletedAt,
+	}, extra...)...)
+}
+
+// userEventPayload restituisce una copia dell'utente senza password da usare come payload degli eventi.
+func userEventPayload(user *app.User) app.User {
+	u := *user
+	u.Password = ""
+	return u
 }
 
 func attachUserAssociations(ctx context.Context, tx *Tx, user *app.User) (err error) {
-	return nil
+	return attachUserAvatars(ctx, tx, []*app.User{user})
 }
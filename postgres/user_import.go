@@ -0,0 +1,298 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"prova/app"
+	"prova/postgres/query"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// defaultImportBatchSize è il numero di righe inserite con una sola query se non indicato nelle opzioni,
+// maxImportBatchSize mantiene i parametri della query sotto il limite di 65535 di Postgres.
+const (
+	defaultImportBatchSize = 500
+	maxImportBatchSize     = 5000
+)
+
+// importRow è una riga valida in attesa di essere inserita, index è la posizione del suo esito nel report.
+type importRow struct {
+	index int
+	crt   app.UserCreate
+}
+
+// ImportUsers implements app.UserService.
+// Le righe sono inserite a blocchi, ciascuno nella propria transazione, oppure in un'unica
+// transazione con AllOrNothing, che smette di inserire alla prima riga non importata. In entrambi
+// i casi il file viene letto fino in fondo per restituire l'esito di ogni riga.
+func (s *UserService) ImportUsers(ctx context.Context, src app.UserImportSource, opts app.UserImportOptions) (*app.UserImportReport, error) {
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultImportBatchSize
+	} else if opts.BatchSize > maxImportBatchSize {
+		opts.BatchSize = maxImportBatchSize
+	}
+
+	var tx *Tx
+	if opts.AllOrNothing {
+		var err error
+		if tx, err = s.db.BeginTx(ctx, nil); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+	}
+
+	report := &app.UserImportReport{Results: []app.UserImportResult{}}
+	seen := map[string]bool{}
+	var batch []importRow
+	// failed is set by the first row not imported.
+	var failed bool
+
+	flush := func() error {
+
+		defer func() { batch = batch[:0] }()
+
+		// the rows of a transaction that will be rolled back are not worth hashing and inserting.
+		if len(batch) == 0 || (opts.AllOrNothing && failed) {
+			return nil
+		} else if opts.AllOrNothing {
+			if err := importUsers(ctx, tx, batch, report.Results); err != nil {
+				return err
+			}
+		} else {
+			tx, err := s.db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := importUsers(ctx, tx, batch, report.Results); err != nil {
+				return err
+			} else if err := tx.Commit(); err != nil {
+				return err
+			}
+		}
+
+		for _, row := range batch {
+			failed = failed || report.Results[row.index].Status != app.UserImportStatusCreated
+		}
+
+		return nil
+	}
+
+	for {
+		row, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		result := checkImportRow(row, seen)
+		if result.Status == "" {
+			batch = append(batch, importRow{index: len(report.Results), crt: row.User})
+		} else {
+			failed = true
+		}
+
+		report.Results = append(report.Results, result)
+
+		if len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	if opts.AllOrNothing && !failed {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	summarizeImport(report, opts.AllOrNothing && failed)
+
+	return report, nil
+}
+
+// checkImportRow restituisce l'esito di una riga che non può essere importata, senza Status se la riga
+// è valida e la sua email non è già in seen. Le email sono confrontate senza distinzione tra maiuscole
+// e minuscole, come fa l'indice univoco.
+func checkImportRow(row app.UserImportRow, seen map[string]bool) app.UserImportResult {
+
+	result := app.UserImportResult{Line: row.Line, Email: row.User.Email}
+	email := strings.ToLower(row.User.Email)
+
+	if err := validateImportRow(row); err != nil {
+		result.Status, result.Error = app.UserImportStatusInvalid, app.ErrorMessage(err)
+		if row.Err == nil && row.User.Phone <= 0 {
+			result.Status = app.UserImportStatusInvalidPhone
+		}
+	} else if seen[email] {
+		result.Status, result.Error = app.UserImportStatusDuplicateEmail, "Email is repeated in the file"
+	} else {
+		seen[email] = true
+	}
+
+	return result
+}
+
+// summarizeImport conta gli esiti delle righe del report. Se l'import è stato annullato nessuno user è
+// stato creato e le righe valide, comprese quelle inserite prima dell'annullamento, sono saltate.
+func summarizeImport(report *app.UserImportReport, rolledBack bool) {
+
+	report.Committed = !rolledBack
+	report.Created, report.Failed = 0, 0
+
+	for i := range report.Results {
+
+		r := &report.Results[i]
+
+		if rolledBack && (r.Status == "" || r.Status == app.UserImportStatusCreated) {
+			r.Status, r.UserID = app.UserImportStatusSkipped, 0
+		}
+
+		switch r.Status {
+		case app.UserImportStatusCreated:
+			report.Created++
+		case app.UserImportStatusSkipped:
+			// neither created nor failed.
+		default:
+			report.Failed++
+		}
+	}
+}
+
+// validateImportRow verifica una riga letta dal file come farebbe createUser.
+func validateImportRow(row app.UserImportRow) error {
+
+	if row.Err != nil {
+		return row.Err
+	} else if row.User.Phone <= 0 {
+		return app.Errorf(app.EINVALID, "Invalid phone")
+	}
+
+	return app.User{
+		Name:     row.User.Name,
+		Surname:  row.User.Surname,
+		Email:    row.User.Email,
+		Password: row.User.Password,
+		Phone:    row.User.Phone,
+	}.Validate()
+}
+
+// importUsers inserisce le righe con una sola query, impostando in results l'esito di ciascuna.
+// Le email già usate da user non eliminati, senza distinzione tra maiuscole e minuscole, sono scartate
+// dall'indice univoco senza interrompere la transazione.
+func importUsers(ctx context.Context, tx *Tx, rows []importRow, results []app.UserImportResult) error {
+
+	users, err := hashImportPasswords(ctx, tx, rows)
+	if err != nil {
+		return err
+	}
+
+	b := query.Insert("users").
+		Columns("name", "surname", "email", "password", "phone", "version", "created_at", "updated_at")
+	for _, user := range users {
+		b.Values(user.Name, user.Surname, user.Email, user.Password, user.Phone, user.Version, user.CreatedAt, user.UpdatedAt)
+	}
+
+	stmt, args := b.
+		Suffix("ON CONFLICT (lower(email)) WHERE deleted_at IS NULL DO NOTHING").
+		Returning("id", "email").
+		Build()
+
+	rs, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return app.Errorf(app.EINTERNAL, "Error importing users: %v", err)
+	}
+	defer rs.Close()
+
+	ids := make(map[string]int64, len(rows))
+	for rs.Next() {
+		var id int64
+		var email string
+		if err := rs.Scan(&id, &email); err != nil {
+			return app.Errorf(app.EINTERNAL, "Error importing users: %v", err)
+		}
+		ids[strings.ToLower(email)] = id
+	}
+	if err := rs.Err(); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error importing users: %v", err)
+	}
+	// the connection must be released before writing the audit log & events.
+	rs.Close()
+
+	for i, row := range rows {
+
+		result := &results[row.index]
+
+		id, ok := ids[strings.ToLower(users[i].Email)]
+		if !ok {
+			result.Status, result.Error = app.UserImportStatusDuplicateEmail, "Email already exists"
+			continue
+		}
+
+		users[i].ID = id
+		result.Status, result.UserID = app.UserImportStatusCreated, id
+
+		if err := createAuditLog(ctx, tx, app.AuditActionCreate, app.AuditEntityUser, id, nil, users[i]); err != nil {
+			return err
+		} else if err := publishEvent(ctx, tx, app.EventTypeUserCreated, id, userEventPayload(users[i])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashImportPasswords crea gli user delle righe calcolando gli hash delle password in parallelo,
+// bcrypt è volutamente lento e su migliaia di righe sarebbe il collo di bottiglia dell'import.
+func hashImportPasswords(ctx context.Context, tx *Tx, rows []importRow) ([]*app.User, error) {
+
+	users := make([]*app.User, len(rows))
+	errs := make([]error, len(rows))
+
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+
+	for i, row := range rows {
+
+		users[i] = &app.User{
+			Name:      row.crt.Name,
+			Surname:   row.crt.Surname,
+			Email:     row.crt.Email,
+			Phone:     row.crt.Phone,
+			Version:   1,
+			CreatedAt: tx.Now(),
+			UpdatedAt: tx.Now(),
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, password string) {
+			defer func() { <-sem; wg.Done() }()
+			if ctx.Err() != nil {
+				errs[i] = ctx.Err()
+				return
+			}
+			hash, err := HashPassword(password)
+			users[i].Password, errs[i] = string(hash), err
+		}(i, row.crt.Password)
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error hashing passwords: %v", err)
+	}
+
+	return users, nil
+}
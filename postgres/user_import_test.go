@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"prova/app"
+)
+
+func TestCheckImportRow(t *testing.T) {
+
+	valid := func(email string) app.UserImportRow {
+		return app.UserImportRow{Line: 2, User: app.UserCreate{Name: "Mario", Surname: "Rossi", Email: email, Password: "secret", Phone: 3331234567}}
+	}
+
+	noPhone := valid("mario@example.com")
+	noPhone.User.Phone = 0
+
+	noName := valid("mario@example.com")
+	noName.User.Name = ""
+
+	unreadable := app.UserImportRow{Line: 2, Err: app.Errorf(app.EINVALID, "Invalid CSV row")}
+
+	tests := []struct {
+		name   string
+		seen   []string
+		row    app.UserImportRow
+		status string
+	}{
+		{"valid", nil, valid("mario@example.com"), ""},
+		{"unreadable", nil, unreadable, app.UserImportStatusInvalid},
+		{"invalid phone", nil, noPhone, app.UserImportStatusInvalidPhone},
+		{"invalid user", nil, noName, app.UserImportStatusInvalid},
+		{"invalid email", nil, valid("mario"), app.UserImportStatusInvalid},
+		{"repeated email", []string{"mario@example.com"}, valid("mario@example.com"), app.UserImportStatusDuplicateEmail},
+		{"repeated email with another case", []string{"mario@example.com"}, valid("Mario@Example.com"), app.UserImportStatusDuplicateEmail},
+		{"other email", []string{"anna@example.com"}, valid("mario@example.com"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			seen := map[string]bool{}
+			for _, email := range tt.seen {
+				seen[email] = true
+			}
+
+			result := checkImportRow(tt.row, seen)
+			if result.Status != tt.status {
+				t.Errorf("status = %q, want %q", result.Status, tt.status)
+			}
+			if result.Line != tt.row.Line || result.Email != tt.row.User.Email {
+				t.Errorf("result = %+v, want line %d and email %q", result, tt.row.Line, tt.row.User.Email)
+			}
+			if (tt.status == "") != (result.Error == "") {
+				t.Errorf("error = %q for status %q", result.Error, tt.status)
+			}
+			// only the accepted rows reserve their email.
+			if want := tt.status == "" || len(tt.seen) > 0; len(seen) > 0 != want {
+				t.Errorf("seen = %v", seen)
+			}
+		})
+	}
+}
+
+func TestSummarizeImport(t *testing.T) {
+
+	created := func(id int64) app.UserImportResult {
+		return app.UserImportResult{Status: app.UserImportStatusCreated, UserID: id}
+	}
+	duplicate := app.UserImportResult{Status: app.UserImportStatusDuplicateEmail, Error: "Email already exists"}
+	invalid := app.UserImportResult{Status: app.UserImportStatusInvalid, Error: "Name is required"}
+	skipped := app.UserImportResult{Status: app.UserImportStatusSkipped}
+
+	tests := []struct {
+		name       string
+		results    []app.UserImportResult
+		rolledBack bool
+		want       app.UserImportReport
+	}{
+		{
+			name:    "all created",
+			results: []app.UserImportResult{created(1), created(2)},
+			want:    app.UserImportReport{Results: []app.UserImportResult{created(1), created(2)}, Created: 2, Committed: true},
+		},
+		{
+			name:    "partial",
+			results: []app.UserImportResult{created(1), duplicate, invalid},
+			want:    app.UserImportReport{Results: []app.UserImportResult{created(1), duplicate, invalid}, Created: 1, Failed: 2, Committed: true},
+		},
+		{
+			name:       "rolled back",
+			results:    []app.UserImportResult{created(1), duplicate, {}, invalid},
+			rolledBack: true,
+			want:       app.UserImportReport{Results: []app.UserImportResult{skipped, duplicate, skipped, invalid}, Failed: 2},
+		},
+		{
+			name:    "empty",
+			results: []app.UserImportResult{},
+			want:    app.UserImportReport{Results: []app.UserImportResult{}, Committed: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			report := &app.UserImportReport{Results: tt.results}
+			summarizeImport(report, tt.rolledBack)
+
+			if !reflect.DeepEqual(*report, tt.want) {
+				t.Errorf("report = %+v, want %+v", *report, tt.want)
+			}
+		})
+	}
+}
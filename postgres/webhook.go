@@ -0,0 +1,726 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"prova/app"
+	"prova/common"
+	"prova/postgres/query"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/lib/pq"
+)
+
+var _ app.WebhookService = (*WebhookService)(nil)
+
+// WebhookService gestisce i webhook e invia le consegne pendenti tramite il Sender.
+type WebhookService struct {
+	db *DB
+
+	cancel func()
+	wg     sync.WaitGroup
+
+	// Sender is used to deliver the payloads to the endpoints.
+	Sender app.WebhookSender
+
+	// PollInterval is the time between two polls of the pending deliveries.
+	PollInterval time.Duration
+	// BatchSize is the max number of deliveries locked for each poll.
+	BatchSize int
+	// MaxAttempts is the number of attempts after which a delivery is marked as failed.
+	MaxAttempts int
+	// MinBackoff & MaxBackoff bound the exponential delay between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// ClaimTimeout is the time a claimed delivery is hidden from the other workers, it must be longer
+	// than the Sender timeout. After it the delivery is sent again, e.g. if the process crashed.
+	ClaimTimeout time.Duration
+
+	Logger log.Logger
+}
+
+func NewWebhookService(db *DB) *WebhookService {
+	return &WebhookService{
+		db:           db,
+		PollInterval: 1 * time.Second,
+		BatchSize:    20,
+		MaxAttempts:  8,
+		MinBackoff:   10 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		ClaimTimeout: 1 * time.Minute,
+		Logger:       log.Root(),
+	}
+}
+
+// Open starts the goroutine that sends the pending deliveries.
+func (s *WebhookService) Open() error {
+
+	if s.Sender == nil {
+		return app.Errorf(app.ENOTINJECTED, "Webhook sender not injected")
+	}
+
+	ctx, cancel := context.WithCancel(s.db.ctx)
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.monitor(ctx)
+	}()
+
+	return nil
+}
+
+// Close stops the delivery goroutine and waits for the running poll to finish.
+func (s *WebhookService) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// HandleEvent is an app.EventHandler that creates a delivery for each webhook interested in the event.
+// When called by the EventService it joins the outbox transaction, so deliveries are created exactly once.
+func (s *WebhookService) HandleEvent(ctx context.Context, e app.Event) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createWebhookDeliveries(ctx, tx, e); err != nil {
+		return err
+	} else if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateWebhook implements app.WebhookService.
+func (s *WebhookService) CreateWebhook(ctx context.Context, crt app.WebhookCreate) (*app.Webhook, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	webhook, err := createWebhook(ctx, tx, crt)
+	if err != nil {
+		return nil, err
+	} else if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook implements app.WebhookService.
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id int64) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteWebhook(ctx, tx, id); err != nil {
+		return err
+	} else if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FindWebhookByID implements app.WebhookService.
+func (s *WebhookService) FindWebhookByID(ctx context.Context, id int64) (*app.Webhook, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findWebhookByID(ctx, tx, id)
+}
+
+// FindWebhooks implements app.WebhookService.
+func (s *WebhookService) FindWebhooks(ctx context.Context, filter app.WebhookFilter) ([]*app.Webhook, int, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	return findWebhooks(ctx, tx, filter)
+}
+
+// UpdateWebhook implements app.WebhookService.
+func (s *WebhookService) UpdateWebhook(ctx context.Context, id int64, upd app.WebhookUpdate) (*app.Webhook, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	webhook, err := updateWebhook(ctx, tx, id, upd)
+	if err != nil {
+		return nil, err
+	} else if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+// FindWebhookDeliveries implements app.WebhookService.
+func (s *WebhookService) FindWebhookDeliveries(ctx context.Context, filter app.WebhookDeliveryFilter) ([]*app.WebhookDelivery, int, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	return findWebhookDeliveries(ctx, tx, filter)
+}
+
+// PurgeWebhookDeliveries elimina le consegne andate a buon fine da più di retention, restituisce il numero di consegne eliminate.
+func (s *WebhookService) PurgeWebhookDeliveries(ctx context.Context, retention time.Duration) (int64, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before := tx.now.Add(-retention)
+
+	stmt, args := query.Delete("webhook_deliveries").
+		Where(query.Eq("status", app.WebhookDeliveryStatusSucceeded), query.Expr("delivered_at < ?", before)).
+		Build()
+
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, app.Errorf(app.EINTERNAL, "Error purging webhook deliveries: %v", err)
+	} else if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// monitor invia le consegne pendenti finché il context non viene cancellato.
+func (s *WebhookService) monitor(ctx context.Context) {
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := s.send(ctx)
+			if err != nil {
+				app.LogErr(s.Logger, err)
+				break
+			} else if n < s.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
+
+// send reclama un batch di consegne pendenti e le invia fuori dalla transazione, così un endpoint lento
+// non tiene bloccate le righe e la connessione. L'esito di ogni tentativo è salvato con una transazione
+// separata. Restituisce il numero di consegne processate.
+func (s *WebhookService) send(ctx context.Context) (int, error) {
+
+	deliveries, webhooks, err := s.claimWebhookDeliveries(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, d := range deliveries {
+
+		statusCode, err := s.Sender.SendWebhook(ctx, webhooks[i], d)
+
+		// on shutdown the claim expires and the delivery is sent again, without counting the attempt.
+		if ctx.Err() != nil {
+			return i, nil
+		}
+
+		if err := s.recordWebhookDelivery(ctx, d, statusCode, err); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// claimWebhookDeliveries blocca un batch di consegne pendenti con FOR UPDATE SKIP LOCKED e ne sposta
+// il prossimo tentativo di ClaimTimeout, così dopo il commit non vengono prese dagli altri worker.
+func (s *WebhookService) claimWebhookDeliveries(ctx context.Context) ([]*app.WebhookDelivery, []*app.Webhook, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	deliveries, webhooks, err := findPendingWebhookDeliveries(ctx, tx, s.BatchSize)
+	if err != nil {
+		return nil, nil, err
+	} else if len(deliveries) == 0 {
+		return deliveries, webhooks, nil
+	}
+
+	ids := make([]int64, len(deliveries))
+	for i, d := range deliveries {
+		ids[i] = d.ID
+	}
+
+	stmt, args := query.Update("webhook_deliveries").
+		Set("next_attempt_at", tx.now.Add(s.ClaimTimeout)).
+		Where(query.Any("id", ids)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return nil, nil, app.Errorf(app.EINTERNAL, "Error updating webhook delivery: %v", err)
+	} else if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return deliveries, webhooks, nil
+}
+
+// recordWebhookDelivery salva l'esito di un tentativo di consegna, sendErr è l'errore restituito dal Sender.
+func (s *WebhookService) recordWebhookDelivery(ctx context.Context, d *app.WebhookDelivery, statusCode int, sendErr error) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	d.Attempts++
+	d.ResponseStatus = statusCode
+
+	if sendErr != nil {
+
+		app.LogErr(s.Logger, app.Errorf(app.ErrorCode(sendErr), "Error sending webhook delivery %d: %s", d.ID, app.ErrorMessage(sendErr)))
+
+		d.LastError = app.ErrorMessage(sendErr)
+		d.NextAttemptAt = tx.now.Add(common.ExponentialBackoff(d.Attempts, s.MinBackoff, s.MaxBackoff))
+
+		if d.Attempts >= s.MaxAttempts {
+			d.Status = app.WebhookDeliveryStatusFailed
+		}
+
+	} else {
+		d.Status, d.LastError, d.DeliveredAt = app.WebhookDeliveryStatusSucceeded, "", &tx.now
+	}
+
+	if err := updateWebhookDeliveryAttempt(ctx, tx, d); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func createWebhook(ctx context.Context, tx *Tx, crt app.WebhookCreate) (*app.Webhook, error) {
+
+	webhook := &app.Webhook{
+		URL:       crt.URL,
+		Secret:    crt.Secret,
+		Events:    crt.Events,
+		Active:    true,
+		CreatedAt: tx.now,
+	}
+
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+
+	if webhook.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		webhook.Secret = secret
+	}
+
+	if err := webhook.Validate(); err != nil {
+		return nil, err
+	}
+
+	stmt, args := query.Insert("webhooks").
+		Columns("url", "secret", "events", "active", "created_at").
+		Values(webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active, webhook.CreatedAt).
+		Returning("id").
+		Build()
+
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&webhook.ID); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error creating webhook: %v", err)
+	}
+
+	return webhook, nil
+}
+
+// deleteWebhook elimina un webhook, le consegne vengono eliminate in cascata.
+func deleteWebhook(ctx context.Context, tx *Tx, id int64) error {
+
+	webhook, err := findWebhookByID(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	stmt, args := query.Delete("webhooks").Where(query.Eq("id", webhook.ID)).Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error deleting webhook: %v", err)
+	}
+
+	return nil
+}
+
+// findWebhookByID cerca un webhook per ID.
+func findWebhookByID(ctx context.Context, tx *Tx, id int64) (*app.Webhook, error) {
+
+	w, _, err := findWebhooks(ctx, tx, app.WebhookFilter{ID: &id})
+	if err != nil {
+		return nil, err
+	} else if len(w) == 0 {
+		return nil, app.Errorf(app.ENOTFOUND, "Webhook not found")
+	}
+
+	return w[0], nil
+}
+
+// findWebhooks cerca i webhook, restituisce il numero totale di risultati al netto della paginazione.
+func findWebhooks(ctx context.Context, tx *Tx, filter app.WebhookFilter) (_ []*app.Webhook, n int, err error) {
+
+	b := query.Select(
+		"webhooks.id",
+		"webhooks.url",
+		"webhooks.secret",
+		"webhooks.events",
+		"webhooks.active",
+		"webhooks.created_at",
+		"COUNT(*) OVER() AS total_count",
+	).From("webhooks")
+
+	if v := filter.ID; v != nil {
+		b.Where(query.Eq("webhooks.id", *v))
+	}
+
+	if v := filter.Active; v != nil {
+		b.Where(query.Eq("webhooks.active", *v))
+	}
+
+	stmt, args := b.OrderBy(query.OrderTerm{Column: "webhooks.id", Desc: true}).
+		Page(filter.Limit, filter.Page).
+		Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error querying webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	webhooks := []*app.Webhook{}
+
+	for rows.Next() {
+
+		var webhook app.Webhook
+
+		if err := rows.Scan(
+			&webhook.ID,
+			&webhook.URL,
+			&webhook.Secret,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+			&webhook.CreatedAt,
+			&n,
+		); err != nil {
+			return nil, 0, app.Errorf(app.EINTERNAL, "Error scanning webhooks: %v", err)
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error iterating webhooks: %v", err)
+	}
+
+	return webhooks, n, nil
+}
+
+// updateWebhook aggiorna un webhook.
+func updateWebhook(ctx context.Context, tx *Tx, id int64, upd app.WebhookUpdate) (*app.Webhook, error) {
+
+	webhook, err := findWebhookByID(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := upd.URL; v.Set {
+		webhook.URL = v.Value
+	}
+
+	if v := upd.Secret; v.Set {
+		webhook.Secret = v.Value
+	}
+
+	if v := upd.Events; v.Set {
+		webhook.Events = v.Value
+		if webhook.Events == nil {
+			webhook.Events = []string{}
+		}
+	}
+
+	if v := upd.Active; v.Set {
+		webhook.Active = v.Value
+	}
+
+	if err := webhook.Validate(); err != nil {
+		return nil, err
+	}
+
+	stmt, args := query.Update("webhooks").
+		Set("url", webhook.URL).
+		Set("secret", webhook.Secret).
+		Set("events", pq.Array(webhook.Events)).
+		Set("active", webhook.Active).
+		Where(query.Eq("id", webhook.ID)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error updating webhook: %v", err)
+	}
+
+	return webhook, nil
+}
+
+// createWebhookDeliveries crea una consegna pendente per ogni webhook attivo interessato all'evento.
+// Le consegne già esistenti per lo stesso evento vengono ignorate, così un evento riconsegnato dall'outbox non viene duplicato.
+func createWebhookDeliveries(ctx context.Context, tx *Tx, e app.Event) error {
+
+	active := true
+
+	webhooks, _, err := findWebhooks(ctx, tx, app.WebhookFilter{Active: &active})
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return app.Errorf(app.EINTERNAL, "Error encoding webhook payload: %v", err)
+	}
+
+	for _, webhook := range webhooks {
+
+		if !webhook.Accepts(e.Type) {
+			continue
+		}
+
+		stmt, args := query.Insert("webhook_deliveries").
+			Columns("webhook_id", "event_id", "event_type", "payload", "status", "next_attempt_at", "created_at").
+			Values(webhook.ID, e.ID, e.Type, string(payload), app.WebhookDeliveryStatusPending, tx.now, tx.now).
+			Suffix("ON CONFLICT (webhook_id, event_id) DO NOTHING").
+			Build()
+
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return app.Errorf(app.EINTERNAL, "Error creating webhook delivery: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// findPendingWebhookDeliveries restituisce le consegne da inviare bloccandone le righe, insieme al relativo webhook.
+func findPendingWebhookDeliveries(ctx context.Context, tx *Tx, limit int) (_ []*app.WebhookDelivery, _ []*app.Webhook, err error) {
+
+	stmt, args := query.Select(
+		"webhook_deliveries.id",
+		"webhook_deliveries.webhook_id",
+		"webhook_deliveries.event_id",
+		"webhook_deliveries.event_type",
+		"webhook_deliveries.payload",
+		"webhook_deliveries.status",
+		"webhook_deliveries.attempts",
+		"webhook_deliveries.response_status",
+		"webhook_deliveries.last_error",
+		"webhook_deliveries.next_attempt_at",
+		"webhook_deliveries.created_at",
+		"webhooks.url",
+		"webhooks.secret",
+	).
+		From("webhook_deliveries INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id").
+		Where(
+			query.Eq("webhook_deliveries.status", app.WebhookDeliveryStatusPending),
+			query.Expr("webhook_deliveries.next_attempt_at <= ?", tx.now),
+		).
+		OrderBy(query.OrderTerm{Column: "webhook_deliveries.next_attempt_at"}, query.OrderTerm{Column: "webhook_deliveries.id"}).
+		Limit(limit, 0).
+		Suffix("FOR UPDATE OF webhook_deliveries SKIP LOCKED").
+		Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, nil, app.Errorf(app.EINTERNAL, "Error querying webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	deliveries, webhooks := []*app.WebhookDelivery{}, []*app.Webhook{}
+
+	for rows.Next() {
+
+		var d app.WebhookDelivery
+		var w app.Webhook
+
+		if err := rows.Scan(
+			&d.ID,
+			&d.WebhookID,
+			&d.EventID,
+			&d.EventType,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			&d.ResponseStatus,
+			&d.LastError,
+			&d.NextAttemptAt,
+			&d.CreatedAt,
+			&w.URL,
+			&w.Secret,
+		); err != nil {
+			return nil, nil, app.Errorf(app.EINTERNAL, "Error scanning webhook deliveries: %v", err)
+		}
+
+		w.ID = d.WebhookID
+
+		deliveries, webhooks = append(deliveries, &d), append(webhooks, &w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, app.Errorf(app.EINTERNAL, "Error iterating webhook deliveries: %v", err)
+	}
+
+	return deliveries, webhooks, nil
+}
+
+// findWebhookDeliveries cerca le consegne dei webhook, restituisce il numero totale di risultati al netto della paginazione.
+func findWebhookDeliveries(ctx context.Context, tx *Tx, filter app.WebhookDeliveryFilter) (_ []*app.WebhookDelivery, n int, err error) {
+
+	b := query.Select(
+		"webhook_deliveries.id",
+		"webhook_deliveries.webhook_id",
+		"webhook_deliveries.event_id",
+		"webhook_deliveries.event_type",
+		"webhook_deliveries.payload",
+		"webhook_deliveries.status",
+		"webhook_deliveries.attempts",
+		"webhook_deliveries.response_status",
+		"webhook_deliveries.last_error",
+		"webhook_deliveries.next_attempt_at",
+		"webhook_deliveries.created_at",
+		"webhook_deliveries.delivered_at",
+		"COUNT(*) OVER() AS total_count",
+	).From("webhook_deliveries")
+
+	if v := filter.WebhookID; v != nil {
+		b.Where(query.Eq("webhook_deliveries.webhook_id", *v))
+	}
+
+	if v := filter.Status; v != nil {
+		b.Where(query.Eq("webhook_deliveries.status", *v))
+	}
+
+	stmt, args := b.OrderBy(query.OrderTerm{Column: "webhook_deliveries.id", Desc: true}).
+		Page(filter.Limit, filter.Page).
+		Build()
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error querying webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*app.WebhookDelivery{}
+
+	for rows.Next() {
+
+		var d app.WebhookDelivery
+
+		if err := rows.Scan(
+			&d.ID,
+			&d.WebhookID,
+			&d.EventID,
+			&d.EventType,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			&d.ResponseStatus,
+			&d.LastError,
+			&d.NextAttemptAt,
+			&d.CreatedAt,
+			&d.DeliveredAt,
+			&n,
+		); err != nil {
+			return nil, 0, app.Errorf(app.EINTERNAL, "Error scanning webhook deliveries: %v", err)
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, app.Errorf(app.EINTERNAL, "Error iterating webhook deliveries: %v", err)
+	}
+
+	return deliveries, n, nil
+}
+
+// updateWebhookDeliveryAttempt salva l'esito dell'ultimo tentativo di consegna.
+func updateWebhookDeliveryAttempt(ctx context.Context, tx *Tx, d *app.WebhookDelivery) error {
+
+	stmt, args := query.Update("webhook_deliveries").
+		Set("status", d.Status).
+		Set("attempts", d.Attempts).
+		Set("response_status", d.ResponseStatus).
+		Set("last_error", d.LastError).
+		Set("next_attempt_at", d.NextAttemptAt).
+		Set("delivered_at", d.DeliveredAt).
+		Where(query.Eq("id", d.ID)).
+		Build()
+
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error updating webhook delivery: %v", err)
+	}
+
+	return nil
+}
+
+// generateWebhookSecret genera un secret casuale per firmare i payload.
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", app.Errorf(app.EINTERNAL, "Error generating webhook secret: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,100 @@
+// Package storage contiene le implementazioni di app.FileStorage.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"prova/app"
+)
+
+var _ app.FileStorage = (*LocalStorage)(nil)
+
+// LocalStorage salva i file in una directory del filesystem locale, la chiave è il percorso relativo.
+type LocalStorage struct {
+	Dir string
+}
+
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{Dir: dir}
+}
+
+// Put implements app.FileStorage.
+// Il contenuto è scritto in un file temporaneo rinominato solo al termine, un file incompleto
+// non sostituisce mai quello esistente.
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, mimeType string) error {
+
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error creating directory: %v", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return app.Errorf(app.EINTERNAL, "Error creating file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return app.Errorf(app.EINTERNAL, "Error writing file: %v", err)
+	} else if n != size {
+		return app.Errorf(app.EINVALID, "File size doesn't match, expected %d bytes, got %d", size, n)
+	} else if err := f.Close(); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error writing file: %v", err)
+	} else if err := os.Rename(f.Name(), p); err != nil {
+		return app.Errorf(app.EINTERNAL, "Error writing file: %v", err)
+	}
+
+	return nil
+}
+
+// Open implements app.FileStorage.
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, app.Errorf(app.ENOTFOUND, "File not found")
+	} else if err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error opening file: %v", err)
+	}
+
+	return f, nil
+}
+
+// Delete implements app.FileStorage.
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return app.Errorf(app.EINTERNAL, "Error deleting file: %v", err)
+	}
+
+	return nil
+}
+
+// path restituisce il percorso del file con la chiave key, che non può uscire da Dir.
+func (s *LocalStorage) path(key string) (string, error) {
+	if err := app.ValidateStorageKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"prova/app"
+)
+
+var _ app.FileStorage = (*S3Storage)(nil)
+
+// s3UnsignedPayload è l'hash del contenuto firmato nelle richieste, il contenuto degli upload
+// non viene letto due volte per calcolarne l'hash.
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Storage salva i file in un bucket S3 o compatibile, come MinIO in locale. Le richieste sono firmate
+// con AWS Signature Version 4.
+type S3Storage struct {
+	// Endpoint è l'URL del servizio, es. https://s3.eu-south-1.amazonaws.com o http://localhost:9000.
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// PathStyle mette il bucket nel percorso invece che nel dominio, richiesto da MinIO.
+	PathStyle bool
+
+	HTTPClient *http.Client
+
+	// Now restituisce l'ora usata per firmare le richieste.
+	Now func() time.Time
+}
+
+func NewS3Storage(endpoint, region, bucket, accessKeyID, secretAccessKey string) *S3Storage {
+	return &S3Storage{
+		Endpoint:        endpoint,
+		Region:          region,
+		Bucket:          bucket,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		HTTPClient:      &http.Client{},
+		Now:             time.Now,
+	}
+}
+
+// Put implements app.FileStorage.
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, mimeType string) error {
+
+	req, err := s.newRequest(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+
+	// the length must be known, S3 doesn't accept chunked uploads without a signed payload.
+	req.ContentLength = size
+	req.Header.Set("Content-Type", mimeType)
+
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// Open implements app.FileStorage.
+// Il contenuto è letto con richieste GET con Range a partire dalla posizione corrente, così il
+// download di una parte del file non scarica l'intero oggetto.
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+
+	req, err := s.newRequest(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	return &s3Object{ctx: ctx, s: s, key: key, size: res.ContentLength}, nil
+}
+
+// Delete implements app.FileStorage.
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req)
+	if app.ErrorCode(err) == app.ENOTFOUND {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// newRequest crea la richiesta firmata per l'oggetto con la chiave key.
+func (s *S3Storage) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+
+	if err := app.ValidateStorageKey(key); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Invalid S3 endpoint: %v", err)
+	}
+
+	if s.PathStyle {
+		u.Path = "/" + s.Bucket + "/" + key
+		u.RawPath = "/" + s3Escape(s.Bucket) + "/" + s3Escape(key)
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path, u.RawPath = "/"+key, "/"+s3Escape(key)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error creating S3 request: %v", err)
+	}
+
+	return req, nil
+}
+
+// do firma ed esegue la richiesta, una risposta non riuscita è restituita come errore.
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+
+	s.sign(req, s.Now().UTC())
+
+	res, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, app.Errorf(app.EINTERNAL, "Error calling S3: %v", err)
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		if res.StatusCode == http.StatusNotFound {
+			return nil, app.Errorf(app.ENOTFOUND, "File not found")
+		}
+		return nil, app.Errorf(app.EINTERNAL, "S3 %s %s failed with status %d: %s", req.Method, req.URL.Path, res.StatusCode, b)
+	}
+
+	return res, nil
+}
+
+// sign aggiunge alla richiesta gli header della firma AWS Signature Version 4.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	headers := []string{"host", "range", "x-amz-content-sha256", "x-amz-date"}
+
+	var canonicalHeaders strings.Builder
+	signed := []string{}
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		} else if v == "" {
+			continue
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", h, strings.TrimSpace(v))
+		signed = append(signed, h)
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, v := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign)),
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape codifica il percorso come richiesto dalla firma, lasciando solo i caratteri non riservati e /.
+func s3Escape(p string) string {
+
+	var b strings.Builder
+
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// s3Object legge un oggetto S3 dalla posizione corrente, la richiesta è aperta alla prima lettura
+// dopo ogni Seek che cambia la posizione.
+type s3Object struct {
+	ctx  context.Context
+	s    *S3Storage
+	key  string
+	size int64
+	pos  int64
+	body io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+
+	if o.pos >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+
+		req, err := o.s.newRequest(o.ctx, http.MethodGet, o.key, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(o.pos, 10)+"-")
+
+		res, err := o.s.do(req)
+		if err != nil {
+			return 0, err
+		} else if res.StatusCode != http.StatusPartialContent && o.pos > 0 {
+			res.Body.Close()
+			return 0, app.Errorf(app.EINTERNAL, "S3 ignored the requested range")
+		}
+
+		o.body = res.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.pos += int64(n)
+
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+
+	pos := offset
+	switch whence {
+	case io.SeekCurrent:
+		pos += o.pos
+	case io.SeekEnd:
+		pos += o.size
+	}
+
+	if pos < 0 {
+		return 0, app.Errorf(app.EINVALID, "Negative position")
+	}
+
+	if pos != o.pos && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.pos = pos
+
+	return pos, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"prova/postgres"
+
+	"github.com/inconshreveable/log15"
+)
+
+// purgeFunc elimina i record più vecchi di retention, calcolata con l'orario della transazione,
+// e restituisce il numero di record eliminati.
+type purgeFunc func(ctx context.Context, retention time.Duration) (int64, error)
+
+// scheduleMaintenanceTasks registra i task di manutenzione ricorrenti.
+func scheduleMaintenanceTasks(
+	scheduler *postgres.SchedulerService,
+	eventService *postgres.EventService,
+	jobService *postgres.JobService,
+	webhookService *postgres.WebhookService,
+	userService *postgres.UserService,
+	adminService *postgres.AdminService,
+	cfg Config,
+	logger log15.Logger,
+) error {
+
+	tasks := []struct {
+		name      string
+		spec      string
+		retention time.Duration
+		purge     purgeFunc
+	}{
+		{"purge-outbox", "0 3 * * *", cfg.MaintenanceRetention, eventService.PurgeEvents},
+		{"purge-jobs", "15 3 * * *", cfg.MaintenanceRetention, jobService.PurgeJobs},
+		{"purge-webhook-deliveries", "30 3 * * *", cfg.MaintenanceRetention, webhookService.PurgeWebhookDeliveries},
+		{"purge-deleted-users", "0 4 * * *", cfg.SoftDeleteRetention, userService.PurgeUsers},
+		{"purge-deleted-admins", "15 4 * * *", cfg.SoftDeleteRetention, adminService.PurgeAdmins},
+	}
+
+	for _, t := range tasks {
+		name, retention, purge := t.name, t.retention, t.purge
+		if err := scheduler.Schedule(name, t.spec, func(ctx context.Context) error {
+			n, err := purge(ctx, retention)
+			if err != nil {
+				return err
+			}
+			logger.Info("Purged records", "task", name, "count", n)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}